@@ -0,0 +1,187 @@
+// Command migrate manages the database schema with the migrations embedded
+// from db/migrations:
+//
+//	migrate up                apply every pending migration
+//	migrate down N            roll back the last N migrations
+//	migrate goto V            migrate up or down to version V
+//	migrate force V           mark version V as applied and clean, after fixing a failed migration by hand
+//	migrate version           print the current version
+//	migrate create NAME       add an empty up/down pair to db/migrations
+//
+// The database comes from the same settings as the server (-config,
+// CONFIG_FILE, .env and the DB_* or DATABASE_URL variables).
+package main
+
+import (
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/migrations"
+	"ezwait/pkg/logger"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+func main() {
+	configFile := flag.String("config", "", "YAML or TOML config file, defaults to $CONFIG_FILE")
+	dir := flag.String("dir", migrations.Dir, "directory create writes new migrations to")
+	flag.Usage = usage
+	flag.Parse()
+
+	logger.Init("text", "info")
+
+	if err := run(*configFile, *dir, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+
+		var dirty migrate.ErrDirty
+		if errors.As(err, &dirty) {
+			fmt.Fprintf(os.Stderr, "fix the database by hand, then run: migrate force %d\n", dirty.Version)
+		}
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate [-config file] up | down N | goto V | force V | version | create NAME")
+	flag.PrintDefaults()
+}
+
+func run(configFile, dir string, args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errors.New("missing command")
+	}
+	command, args := args[0], args[1:]
+
+	// To create files without needing a database
+	if command == "create" {
+		if len(args) != 1 {
+			return errors.New("create needs a NAME")
+		}
+		paths, err := migrations.Create(dir, args[0])
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return nil
+	}
+
+	action, err := parse(command, args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadDatabase(configFile)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrations.New(cfg.DSN())
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	err = action(m)
+	if errors.Is(err, migrations.ErrNoChange) {
+		fmt.Println("no change")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return printVersion(m)
+}
+
+// To turn a command and its arguments into what to run against the database
+func parse(command string, args []string) (func(m *migrate.Migrate) error, error) {
+	switch command {
+	case "up":
+		if err := noArgs(command, args); err != nil {
+			return nil, err
+		}
+		return (*migrate.Migrate).Up, nil
+	case "down":
+		n, err := intArg(command, args)
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, errors.New("down needs N of at least 1, there is no \"down all\"")
+		}
+		return func(m *migrate.Migrate) error { return m.Steps(-n) }, nil
+	case "goto":
+		v, err := intArg(command, args)
+		if err != nil {
+			return nil, err
+		}
+		if v < 1 {
+			return nil, errors.New("goto needs a version of at least 1")
+		}
+		return func(m *migrate.Migrate) error { return m.Migrate(uint(v)) }, nil
+	case "force":
+		v, err := intArg(command, args)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *migrate.Migrate) error { return m.Force(v) }, nil
+	case "version":
+		if err := noArgs(command, args); err != nil {
+			return nil, err
+		}
+		return func(*migrate.Migrate) error { return nil }, nil
+	}
+
+	usage()
+	return nil, fmt.Errorf("unknown command %q", command)
+}
+
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrations.ErrNoVersion) {
+		fmt.Println("version: none")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+
+	state := ""
+	if dirty {
+		state = " (dirty)"
+	}
+	fmt.Printf("version: %d%s, latest: %d\n", version, state, latest)
+
+	return nil
+}
+
+func noArgs(command string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%s takes no arguments", command)
+	}
+	return nil
+}
+
+func intArg(command string, args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%s needs one number", command)
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("%s needs a number, got %q", command, args[0])
+	}
+
+	return n, nil
+}
@@ -0,0 +1,57 @@
+// Command schema-check compares the database schema with the GORM models and
+// exits with status 1 when they have drifted apart, e.g. after a migration
+// was forgotten or a model changed without one:
+//
+//	schema-check [-config file]
+//
+// The database comes from the same settings as the server (-config,
+// CONFIG_FILE, .env and the DB_* or DATABASE_URL variables).
+package main
+
+import (
+	"context"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/internal/schemacheck"
+	"ezwait/pkg/logger"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	configFile := flag.String("config", "", "YAML or TOML config file, defaults to $CONFIG_FILE")
+	flag.Parse()
+
+	logger.Init("text", "info")
+
+	drifts, err := run(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "schema-check:", err)
+		os.Exit(1)
+	}
+
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+	if len(drifts) > 0 {
+		fmt.Fprintf(os.Stderr, "schema-check: %d differences between the database and the models\n", len(drifts))
+		os.Exit(1)
+	}
+
+	fmt.Println("schema matches the models")
+}
+
+func run(configFile string) ([]schemacheck.Drift, error) {
+	cfg, err := config.LoadDatabase(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := config.ConnectDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return schemacheck.Check(context.Background(), db, models.All()...)
+}
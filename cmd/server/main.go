@@ -1,47 +1,259 @@
 package main
 
 import (
+	"context"
 	"ezwait/config"
+	"ezwait/internal/health"
+	"ezwait/internal/jobs"
+	"ezwait/internal/mailer"
+	"ezwait/internal/metrics"
+	"ezwait/internal/middleware"
+	"ezwait/internal/migrations"
+	"ezwait/internal/oidc"
+	"ezwait/internal/passwords"
 	"ezwait/internal/routers"
-	"log"
+	"ezwait/internal/services"
+	"ezwait/internal/sms"
+	"ezwait/internal/tracing"
+	"ezwait/internal/utils"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+	"flag"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"gorm.io/gorm"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitStartup = 1
+	exitForced  = 2
 )
 
 func main() {
-	// Connect to DB
-	config.ConnectDB()
+	os.Exit(run())
+}
+
+// To run the server until SIGINT or SIGTERM and return the exit code:
+// 0 after a clean shutdown, 1 when startup fails and 2 when requests or
+// jobs were still running at the shutdown deadline
+func run() int {
+	configFile := flag.String("config", "", "YAML or TOML config file, defaults to $CONFIG_FILE")
+	migrateOnStart := flag.Bool("migrate-on-start", false, "apply pending migrations before serving, one instance at a time")
+	flag.Parse()
+
+	// To load and check every setting before anything else starts
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logger.Error(context.Background(), "Failed to load configuration", "error", err)
+		return exitStartup
+	}
+
+	// To set up structured logging before anything else logs
+	logger.Init(cfg.Log.Format, cfg.Log.Level)
+	logger.Debug(context.Background(), "Loaded configuration", "config", cfg)
+
+	// To set up tracing before anything creates spans
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal("Failed to configure tracing", "error", err)
+	}
+
+	// Connect to DB, waiting for it to come up
+	db, err := config.ConnectDB(cfg.Database)
+	if err != nil {
+		logger.Fatal("Failed to connect to database", "error", err)
+	}
+
+	// To bring the schema up to date, instances starting together wait on an advisory lock
+	if *migrateOnStart {
+		if err := migrations.UpWithLock(context.Background(), cfg.Database.DSN()); err != nil {
+			logger.Fatal("Failed to apply migrations", "error", err)
+		}
+	}
+
+	// To trace queries run with a request or job context
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		logger.Fatal("Failed to register query tracing", "error", err)
+	}
+
+	// To load the JWT signing keys
+	keys, err := utils.LoadKeyRing(cfg.JWT)
+	if err != nil {
+		logger.Fatal("Failed to load JWT signing keys", "error", err)
+	}
+
+	// To build the password policy and hasher
+	policy, hasher, err := passwords.New(cfg.Passwords)
+	if err != nil {
+		logger.Fatal("Failed to configure passwords", "error", err)
+	}
+
+	// To set up outgoing mail
+	m, err := mailer.New(cfg.Mail)
+	if err != nil {
+		logger.Fatal("Failed to configure mailer", "error", err)
+	}
+
+	// To set up outgoing SMS
+	sender, err := sms.New(cfg.SMS)
+	if err != nil {
+		logger.Fatal("Failed to configure SMS sender", "error", err)
+	}
+
+	// To set up social sign-in providers
+	providers, err := oidc.LoadProviders(cfg.OIDC)
+	if err != nil {
+		logger.Fatal("Failed to configure sign-in providers", "error", err)
+	}
 
-	// config.RunMigrations()
-	// config.DB.Exec("ALTER TABLE stylists DROP CONSTRAINT IF EXISTS fk_bookings_stylist;")
+	// To build the services on the database, with the settings and senders they use
+	svc := services.New(db, services.Deps{
+		Accounts:  cfg.Accounts,
+		Exports:   cfg.Exports,
+		JWT:       cfg.JWT,
+		Keys:      keys,
+		Providers: providers,
+		Policy:    policy,
+		Hasher:    hasher,
+		Mailer:    m,
+		SMS:       sender,
+	})
 
-	// To call mark completed bookings every minute
-	// go func() {
-	// 	for {
-	// 		handlers.MarkCompletedBookings()
-	// 		time.Sleep(1 * time.Hour)
-	// 	}
-	// }()
+	// To export the connection pool stats
+	if sqlDB, err := db.DB(); err == nil {
+		if err := metrics.RegisterDBStats(sqlDB); err != nil {
+			logger.Fatal("Failed to register database metrics", "error", err)
+		}
+	}
+
+	// To start the background jobs, stopped by cancelling jobsCtx
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
 
-	// Fiber app
-	app := fiber.New()
+	scheduler := jobs.New(
+		jobs.Job{Name: "complete-bookings", Interval: time.Hour, Run: svc.Bookings.CompleteEnded},
+		jobs.Job{Name: "purge-deleted-accounts", Interval: time.Hour, Run: func(ctx context.Context) error {
+			purged, err := svc.Accounts.PurgeDeletedAccounts(ctx)
+			if purged > 0 {
+				logger.Info(ctx, "Purged deleted accounts", "count", purged)
+			}
+			return err
+		}},
+		jobs.Job{Name: "cleanup-auth-data", Interval: 6 * time.Hour, Run: svc.Cleanup.CleanupExpiredAuthData},
+		jobs.Job{Name: "data-exports", Interval: time.Minute, Run: svc.Exports.ProcessDataExports},
+		jobs.Job{Name: "cleanup-data-exports", Interval: time.Hour, Run: svc.Exports.CleanupExpiredExports},
+	)
+	scheduler.Start(jobsCtx)
+
+	// To register what /readyz checks, the schema must be at the newest embedded migration
+	latestMigration, err := migrations.Latest()
+	if err != nil {
+		logger.Fatal("Failed to read embedded migrations", "error", err)
+	}
+	checks := health.New()
+	checks.Register("database", health.Database(db))
+	checks.Register("migrations", health.Migrations(db, latestMigration))
+	checks.Register("scheduler", health.Scheduler(scheduler))
+
+	// Fiber app, PROXY_HEADER (e.g. X-Forwarded-For on Render) gives c.IP() the real client address
+	app := fiber.New(fiber.Config{
+		ProxyHeader:  cfg.Server.ProxyHeader,
+		ErrorHandler: middleware.ErrorHandler,
+	})
+
+	// To tag, trace, measure, log and guard every request; AccessLog wraps Recover so panics are logged as 500s
+	app.Use(middleware.RequestID, tracing.Middleware, metrics.Middleware, middleware.AccessLog, middleware.Recover)
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "*",
-		AllowMethods:     "GET, POST, PUT, DELETE",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowOrigins:     strings.Join(cfg.Server.CORSOrigins, ", "),
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Device-Name, " + middleware.HeaderRequestID,
+		ExposeHeaders:    middleware.HeaderRequestID,
 		AllowCredentials: false,
 	}))
 
-	// To set up route
-	routers.SetupRoutes(app)
+	// To set up route, emails sent after the response run on tasks so shutdown waits for them
+	tasks := jobs.NewTasks()
+	routers.SetupRoutes(app, cfg, routers.NewHandlers(svc, validation.New(cfg.Accounts.DefaultCountryCode), tasks, checks))
 
 	// Start the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(":" + cfg.Server.Port)
+	}()
+
+	select {
+	case err := <-listenErr:
+		logger.Error(context.Background(), "Server stopped", "error", err)
+		return exitStartup
+	case <-signalCtx.Done():
 	}
-	log.Fatal(app.Listen(":" + port))
+
+	// To let a second signal kill the process right away
+	stopSignals()
+
+	return shutdown(cfg.Server, app, db, checks, scheduler, tasks, stopJobs, shutdownTracing)
+}
+
+// To fail readiness, drain in-flight requests, stop the background jobs, wait
+// for the tasks requests started, flush traces and close the database pool,
+// all within cfg.ShutdownTimeout
+func shutdown(cfg config.ServerConfig, app *fiber.App, db *gorm.DB, checks *health.Registry, scheduler *jobs.Scheduler, tasks *jobs.Tasks, stopJobs context.CancelFunc, shutdownTracing func(context.Context) error) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	logger.Info(ctx, "Shutting down", "timeout", cfg.ShutdownTimeout, "pre_stop_delay", cfg.PreStopDelay)
+	code := exitOK
+
+	// To fail readiness and keep serving until the load balancer has seen it,
+	// closing the listener right away would refuse requests still routed here
+	checks.Drain()
+	time.Sleep(cfg.PreStopDelay)
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		logger.Error(ctx, "Requests still running at the shutdown deadline", "error", err)
+		code = exitForced
+	}
+
+	// No request is left to start a task once the app has shut down
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		scheduler.Wait()
+		tasks.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		logger.Error(ctx, "Background jobs or tasks still running at the shutdown deadline")
+		code = exitForced
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error(ctx, "Failed to flush traces", "error", err)
+	}
+
+	if sqlDB, err := db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			logger.Error(ctx, "Failed to close database pool", "error", err)
+		}
+	}
+
+	if code == exitOK {
+		logger.Info(ctx, "Shutdown complete")
+	}
+
+	return code
 }
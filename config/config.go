@@ -1,66 +1,41 @@
 package config
 
 import (
+	"context"
+	"ezwait/pkg/logger"
 	"fmt"
-	"log"
-	"os"
+	"time"
 
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB
-
-func ConnectDB() {
-	// To only load .env file in local development
-	if os.Getenv("GO_ENV") != "production" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatal("Error loading .env file")
+// To connect to Postgres, retrying with backoff until cfg.ConnectTimeout
+// runs out, so the app can start before the database is up
+func ConnectDB(cfg DatabaseConfig) (*gorm.DB, error) {
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	delay := 500 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{
+			PrepareStmt: false,
+			Logger:      logger.NewGormLogger(cfg.SlowQuery),
+		})
+		if err == nil {
+			logger.Info(context.Background(), "✅ Connected to the database successfully", "attempts", attempt)
+			return db, nil
 		}
-	}
 
-	// Connection string
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("SSL_MODE"),
-	)
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("failed to connect to database after %d attempts: %w", attempt, err)
+		}
 
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-		PrepareStmt: false,
-		Logger:      logger.Default.LogMode(logger.Info),
-	})
+		logger.Warn(context.Background(), "Database not reachable, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+		time.Sleep(delay)
 
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		delay *= 2
+		if delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
 	}
-
-	fmt.Println("✅ Connected to the database successfully")
-}
-
-func RunMigrations() {
-	// DB.Migrator().DropTable(
-	// 	&models.User{},
-	// 	&models.Stylist{},
-	// 	&models.Booking{},
-	// )
-
-	// err := DB.AutoMigrate(
-	// 	&models.User{},
-	// 	&models.Stylist{},
-	// 	&models.Booking{},
-	// )
-
-	// if err != nil {
-	// 	log.Fatal("Failed to migrate database:", err)
-	// }
-
-	fmt.Println("Migrations completed successfully")
 }
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// To load the config, later sources overriding earlier ones:
+//
+//  1. the defaults from Default
+//  2. the YAML or TOML file at path (or CONFIG_FILE), when one is given
+//  3. the environment, which outside production also takes .env
+//
+// The result is validated before it is returned.
+func Load(path string) (*Config, error) {
+	cfg, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// To load only the database settings, validating nothing else, for the
+// migrate command
+func LoadDatabase(path string) (DatabaseConfig, error) {
+	cfg, err := read(path)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+
+	if err := cfg.Database.Validate(); err != nil {
+		return DatabaseConfig{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg.Database, nil
+}
+
+// To read the defaults, file and environment into a config, unchecked
+func read(path string) (*Config, error) {
+	cfg := Default()
+
+	if err := loadDotEnv(); err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+
+	// To pick the log format from the environment when none was set
+	if cfg.Log.Format == "" {
+		cfg.Log.Format = "text"
+		if cfg.Production() {
+			cfg.Log.Format = "json"
+		}
+	}
+
+	return &cfg, nil
+}
+
+// To load .env into the environment outside production, without replacing
+// variables that are already set. A missing .env file is fine.
+func loadDotEnv() error {
+	if os.Getenv("GO_ENV") == "production" {
+		return nil
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("loading .env: %w", err)
+	}
+
+	return nil
+}
+
+// To decode a .yaml, .yml or .toml file over cfg, unknown keys are errors so
+// typos don't go unnoticed
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+
+	return nil
+}
+
+// To override cfg with every variable that is set. Malformed values are
+// reported together rather than silently ignored.
+func applyEnv(cfg *Config) error {
+	env := envReader{}
+
+	env.string("GO_ENV", &cfg.Env)
+
+	env.string("PORT", &cfg.Server.Port)
+	env.string("PROXY_HEADER", &cfg.Server.ProxyHeader)
+	env.list("CORS_ORIGINS", &cfg.Server.CORSOrigins)
+	env.duration("SHUTDOWN_TIMEOUT", time.Second, &cfg.Server.ShutdownTimeout)
+	env.duration("SHUTDOWN_PRE_STOP_DELAY", time.Second, &cfg.Server.PreStopDelay)
+
+	env.string("DATABASE_URL", &cfg.Database.URL)
+	env.string("DB_HOST", &cfg.Database.Host)
+	env.string("DB_PORT", &cfg.Database.Port)
+	env.string("DB_USER", &cfg.Database.User)
+	env.string("DB_PASSWORD", &cfg.Database.Password)
+	env.string("DB_NAME", &cfg.Database.Name)
+	env.string("SSL_MODE", &cfg.Database.SSLMode)
+	env.duration("DB_CONNECT_TIMEOUT", time.Second, &cfg.Database.ConnectTimeout)
+	env.duration("DB_SLOW_QUERY_MS", time.Millisecond, &cfg.Database.SlowQuery)
+
+	env.string("LOG_FORMAT", &cfg.Log.Format)
+	env.string("LOG_LEVEL", &cfg.Log.Level)
+
+	env.string("JWT_SECRET", &cfg.JWT.Secret)
+	env.string("JWT_SECRET_FILE", &cfg.JWT.SecretFile)
+	env.string("JWT_PRIVATE_KEY_FILE", &cfg.JWT.PrivateKeyFile)
+	env.string("JWT_ALG", &cfg.JWT.Algorithm)
+	env.string("JWT_KID", &cfg.JWT.KeyID)
+	env.string("JWT_KEYS_FILE", &cfg.JWT.KeysFile)
+	env.duration("JWT_ACCESS_TTL", time.Second, &cfg.JWT.AccessTTL)
+	env.duration("JWT_REFRESH_TTL", time.Second, &cfg.JWT.RefreshTTL)
+
+	env.int("PASSWORD_MIN_LENGTH", &cfg.Passwords.MinLength)
+	env.int("PASSWORD_MAX_LENGTH", &cfg.Passwords.MaxLength)
+	env.list("PASSWORD_REQUIRE", &cfg.Passwords.Require)
+	env.bool("PASSWORD_CHECK_BREACHED", &cfg.Passwords.CheckBreached)
+	env.string("BREACHED_PASSWORDS_DIR", &cfg.Passwords.BreachedDir)
+	env.string("PASSWORD_HASH_ALGO", &cfg.Passwords.HashAlgorithm)
+	env.int("PASSWORD_BCRYPT_COST", &cfg.Passwords.BcryptCost)
+	env.int("PASSWORD_ARGON2_MEMORY_KB", &cfg.Passwords.Argon2MemoryKB)
+	env.int("PASSWORD_ARGON2_ITERATIONS", &cfg.Passwords.Argon2Iterations)
+	env.int("PASSWORD_ARGON2_PARALLELISM", &cfg.Passwords.Argon2Parallelism)
+
+	env.string("MAIL_DRIVER", &cfg.Mail.Driver)
+	env.string("MAIL_DIR", &cfg.Mail.Dir)
+	env.string("MAIL_FROM", &cfg.Mail.From)
+	env.string("SMTP_HOST", &cfg.Mail.SMTPHost)
+	env.string("SMTP_PORT", &cfg.Mail.SMTPPort)
+	env.string("SMTP_USERNAME", &cfg.Mail.SMTPUsername)
+	env.string("SMTP_PASSWORD", &cfg.Mail.SMTPPassword)
+
+	env.string("SMS_DRIVER", &cfg.SMS.Driver)
+	env.string("SMS_DIR", &cfg.SMS.Dir)
+	env.string("TWILIO_ACCOUNT_SID", &cfg.SMS.TwilioAccountSID)
+	env.string("TWILIO_AUTH_TOKEN", &cfg.SMS.TwilioAuthToken)
+	env.string("TWILIO_FROM", &cfg.SMS.TwilioFrom)
+
+	applyOIDCEnv(cfg)
+
+	env.string("DEFAULT_COUNTRY_CODE", &cfg.Accounts.DefaultCountryCode)
+	env.int("ACCOUNT_DELETION_GRACE_DAYS", &cfg.Accounts.DeletionGraceDays)
+	env.string("PASSWORD_RESET_URL", &cfg.Accounts.PasswordResetURL)
+	env.bool("REQUIRE_VERIFIED_EMAIL_FOR_BOOKINGS", &cfg.Accounts.RequireVerifiedEmailForBookings)
+	env.string("TOTP_ISSUER", &cfg.Accounts.TOTPIssuer)
+	env.list("TWO_FACTOR_REQUIRED_ROLES", &cfg.Accounts.TwoFactorRequiredRoles)
+
+	env.string("EXPORT_DIR", &cfg.Exports.Dir)
+
+	env.string("METRICS_TOKEN", &cfg.Metrics.Token)
+
+	env.string("OTEL_TRACES_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+
+	return errors.Join(env.errs...)
+}
+
+// To add or update the providers listed in OIDC_PROVIDERS (e.g. "google,apple")
+// from OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL,
+// OIDC_<NAME>_ISSUER and, for apple, OIDC_APPLE_TEAM_ID, OIDC_APPLE_KEY_ID and
+// OIDC_APPLE_PRIVATE_KEY_FILE
+func applyOIDCEnv(cfg *Config) {
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		var provider *OIDCProvider
+		for i := range cfg.OIDC {
+			if cfg.OIDC[i].Name == name {
+				provider = &cfg.OIDC[i]
+			}
+		}
+		if provider == nil {
+			cfg.OIDC = append(cfg.OIDC, OIDCProvider{Name: name})
+			provider = &cfg.OIDC[len(cfg.OIDC)-1]
+		}
+
+		env := envReader{}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		env.string(prefix+"ISSUER", &provider.Issuer)
+		env.string(prefix+"CLIENT_ID", &provider.ClientID)
+		env.string(prefix+"CLIENT_SECRET", &provider.ClientSecret)
+		env.string(prefix+"REDIRECT_URL", &provider.RedirectURL)
+		env.string(prefix+"TEAM_ID", &provider.TeamID)
+		env.string(prefix+"KEY_ID", &provider.KeyID)
+		env.string(prefix+"PRIVATE_KEY_FILE", &provider.PrivateKeyFile)
+	}
+}
+
+// envReader copies set variables into config fields and collects parse errors
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) string(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		*target = value
+	}
+}
+
+func (r *envReader) int(name string, target *int) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be a whole number, got %q", name, raw))
+		return
+	}
+	*target = value
+}
+
+func (r *envReader) bool(name string, target *bool) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be true or false, got %q", name, raw))
+		return
+	}
+	*target = value
+}
+
+// To read a comma-separated list, blank entries are dropped
+func (r *envReader) list(name string, target *[]string) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return
+	}
+
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	*target = values
+}
+
+// To read a duration, a bare number counts in unit so the existing
+// SHUTDOWN_TIMEOUT=20 and DB_SLOW_QUERY_MS=200 forms keep working
+func (r *envReader) duration(name string, unit time.Duration, target *time.Duration) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return
+	}
+
+	if n, err := strconv.Atoi(raw); err == nil {
+		*target = time.Duration(n) * unit
+		return
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s must be a number or a duration such as 30s, got %q", name, raw))
+		return
+	}
+	*target = value
+}
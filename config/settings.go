@@ -0,0 +1,382 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting the app reads at startup. It is loaded once by
+// Load and handed to the packages that need it, nothing reads the
+// environment after that.
+type Config struct {
+	// Env is "production" or anything else for local development (GO_ENV)
+	Env string `yaml:"env" toml:"env"`
+
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	JWT       JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Passwords PasswordsConfig `yaml:"passwords" toml:"passwords"`
+	Mail      MailConfig      `yaml:"mail" toml:"mail"`
+	SMS       SMSConfig       `yaml:"sms" toml:"sms"`
+	OIDC      []OIDCProvider  `yaml:"oidc" toml:"oidc"`
+	Accounts  AccountsConfig  `yaml:"accounts" toml:"accounts"`
+	Exports   ExportsConfig   `yaml:"exports" toml:"exports"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port            string        `yaml:"port" toml:"port"`
+	ProxyHeader     string        `yaml:"proxy_header" toml:"proxy_header"`
+	CORSOrigins     []string      `yaml:"cors_origins" toml:"cors_origins"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	PreStopDelay    time.Duration `yaml:"pre_stop_delay" toml:"pre_stop_delay"`
+}
+
+type DatabaseConfig struct {
+	// URL, when set, is used instead of the separate fields
+	URL            string        `yaml:"url" toml:"url"`
+	Host           string        `yaml:"host" toml:"host"`
+	Port           string        `yaml:"port" toml:"port"`
+	User           string        `yaml:"user" toml:"user"`
+	Password       string        `yaml:"password" toml:"password"`
+	Name           string        `yaml:"name" toml:"name"`
+	SSLMode        string        `yaml:"ssl_mode" toml:"ssl_mode"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	SlowQuery      time.Duration `yaml:"slow_query" toml:"slow_query"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+}
+
+type JWTConfig struct {
+	Secret         string        `yaml:"secret" toml:"secret"`
+	SecretFile     string        `yaml:"secret_file" toml:"secret_file"`
+	PrivateKeyFile string        `yaml:"private_key_file" toml:"private_key_file"`
+	Algorithm      string        `yaml:"algorithm" toml:"algorithm"`
+	KeyID          string        `yaml:"kid" toml:"kid"`
+	KeysFile       string        `yaml:"keys_file" toml:"keys_file"`
+	AccessTTL      time.Duration `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL     time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl"`
+}
+
+type PasswordsConfig struct {
+	MinLength         int      `yaml:"min_length" toml:"min_length"`
+	MaxLength         int      `yaml:"max_length" toml:"max_length"`
+	Require           []string `yaml:"require" toml:"require"`
+	CheckBreached     bool     `yaml:"check_breached" toml:"check_breached"`
+	BreachedDir       string   `yaml:"breached_dir" toml:"breached_dir"`
+	HashAlgorithm     string   `yaml:"hash_algorithm" toml:"hash_algorithm"`
+	BcryptCost        int      `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	Argon2MemoryKB    int      `yaml:"argon2_memory_kb" toml:"argon2_memory_kb"`
+	Argon2Iterations  int      `yaml:"argon2_iterations" toml:"argon2_iterations"`
+	Argon2Parallelism int      `yaml:"argon2_parallelism" toml:"argon2_parallelism"`
+}
+
+type MailConfig struct {
+	Driver       string `yaml:"driver" toml:"driver"`
+	Dir          string `yaml:"dir" toml:"dir"`
+	From         string `yaml:"from" toml:"from"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort     string `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+}
+
+type SMSConfig struct {
+	Driver           string `yaml:"driver" toml:"driver"`
+	Dir              string `yaml:"dir" toml:"dir"`
+	TwilioAccountSID string `yaml:"twilio_account_sid" toml:"twilio_account_sid"`
+	TwilioAuthToken  string `yaml:"twilio_auth_token" toml:"twilio_auth_token"`
+	TwilioFrom       string `yaml:"twilio_from" toml:"twilio_from"`
+}
+
+// OIDCProvider is one social sign-in provider. Issuer defaults to the
+// well-known one for google and apple; apple can sign its own client secret
+// from TeamID, KeyID and PrivateKeyFile instead of taking ClientSecret.
+type OIDCProvider struct {
+	Name           string `yaml:"name" toml:"name"`
+	Issuer         string `yaml:"issuer" toml:"issuer"`
+	ClientID       string `yaml:"client_id" toml:"client_id"`
+	ClientSecret   string `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL    string `yaml:"redirect_url" toml:"redirect_url"`
+	TeamID         string `yaml:"team_id" toml:"team_id"`
+	KeyID          string `yaml:"key_id" toml:"key_id"`
+	PrivateKeyFile string `yaml:"private_key_file" toml:"private_key_file"`
+}
+
+type AccountsConfig struct {
+	DefaultCountryCode              string   `yaml:"default_country_code" toml:"default_country_code"`
+	DeletionGraceDays               int      `yaml:"deletion_grace_days" toml:"deletion_grace_days"`
+	PasswordResetURL                string   `yaml:"password_reset_url" toml:"password_reset_url"`
+	RequireVerifiedEmailForBookings bool     `yaml:"require_verified_email_for_bookings" toml:"require_verified_email_for_bookings"`
+	TOTPIssuer                      string   `yaml:"totp_issuer" toml:"totp_issuer"`
+	TwoFactorRequiredRoles          []string `yaml:"two_factor_required_roles" toml:"two_factor_required_roles"`
+}
+
+type ExportsConfig struct {
+	// Dir is where export files are written. In production it is required and
+	// must be storage every instance shares, since the instance serving the
+	// download is rarely the one that built the file. Elsewhere it falls back
+	// to a temp directory.
+	Dir string `yaml:"dir" toml:"dir"`
+}
+
+type MetricsConfig struct {
+	// Token guards GET /metrics, which is open when it is empty
+	Token string `yaml:"token" toml:"token"`
+}
+
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout" or "none"; the OTLP endpoint and sampler
+	// are read by the OpenTelemetry SDK from the standard OTEL_* variables
+	Exporter    string `yaml:"exporter" toml:"exporter"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+// Defaults used when neither the config file nor the environment sets a value
+const (
+	DefaultPort              = "3000"
+	DefaultShutdownTimeout   = 20 * time.Second
+	DefaultPreStopDelay      = 5 * time.Second
+	DefaultDBConnectTimeout  = 30 * time.Second
+	DefaultSlowQuery         = 200 * time.Millisecond
+	DefaultAccessTTL         = 15 * time.Minute
+	DefaultRefreshTTL        = 30 * 24 * time.Hour
+	DefaultDeletionGraceDays = 14
+)
+
+// To get a config holding only the defaults
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            DefaultPort,
+			CORSOrigins:     []string{"*"},
+			ShutdownTimeout: DefaultShutdownTimeout,
+			PreStopDelay:    DefaultPreStopDelay,
+		},
+		Database: DatabaseConfig{
+			Port:           "5432",
+			ConnectTimeout: DefaultDBConnectTimeout,
+			SlowQuery:      DefaultSlowQuery,
+		},
+		Log: LogConfig{Level: "info"},
+		JWT: JWTConfig{
+			KeyID:      "default",
+			AccessTTL:  DefaultAccessTTL,
+			RefreshTTL: DefaultRefreshTTL,
+		},
+		Passwords: PasswordsConfig{
+			MinLength:         8,
+			MaxLength:         72,
+			CheckBreached:     true,
+			HashAlgorithm:     "bcrypt",
+			BcryptCost:        10,
+			Argon2MemoryKB:    19 * 1024,
+			Argon2Iterations:  2,
+			Argon2Parallelism: 1,
+		},
+		Mail:     MailConfig{Driver: "log", SMTPPort: "587"},
+		SMS:      SMSConfig{Driver: "console"},
+		Accounts: AccountsConfig{DeletionGraceDays: DefaultDeletionGraceDays, TOTPIssuer: "EzWait"},
+		Tracing:  TracingConfig{Exporter: "none", ServiceName: "ezwait-api"},
+	}
+}
+
+// To tell whether the app runs in production
+func (c *Config) Production() bool {
+	return c.Env == "production"
+}
+
+// To build the Postgres connection URL, URL itself when it is set
+func (d DatabaseConfig) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   net.JoinHostPort(d.Host, d.Port),
+		Path:   "/" + d.Name,
+	}
+	if d.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {d.SSLMode}}.Encode()
+	}
+
+	return u.String()
+}
+
+// To check every setting, all problems are reported together
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("PORT must be a number between 1 and 65535, got %q", c.Server.Port)
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		fail("CORS_ORIGINS must list at least one origin")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.Server.PreStopDelay < 0 || c.Server.PreStopDelay >= c.Server.ShutdownTimeout {
+		fail("SHUTDOWN_PRE_STOP_DELAY must be between 0 and SHUTDOWN_TIMEOUT")
+	}
+
+	if err := c.Database.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.Log.Format {
+	case "", "json", "text":
+	default:
+		fail("LOG_FORMAT must be json or text, got %q", c.Log.Format)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		fail("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	if c.JWT.KeysFile == "" && c.JWT.PrivateKeyFile == "" && c.JWT.Secret == "" && c.JWT.SecretFile == "" {
+		fail("no JWT signing key configured, set JWT_SECRET, JWT_SECRET_FILE, JWT_PRIVATE_KEY_FILE or JWT_KEYS_FILE")
+	}
+	if c.JWT.AccessTTL <= 0 || c.JWT.RefreshTTL <= 0 {
+		fail("JWT_ACCESS_TTL and JWT_REFRESH_TTL must be positive")
+	} else if c.JWT.AccessTTL >= c.JWT.RefreshTTL {
+		fail("JWT_ACCESS_TTL must be shorter than JWT_REFRESH_TTL")
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "smtp":
+		if c.Mail.SMTPHost == "" || c.Mail.From == "" {
+			fail("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+		}
+	default:
+		fail("unknown MAIL_DRIVER %q", c.Mail.Driver)
+	}
+
+	switch c.SMS.Driver {
+	case "console":
+	case "twilio":
+		if c.SMS.TwilioAccountSID == "" || c.SMS.TwilioAuthToken == "" || c.SMS.TwilioFrom == "" {
+			fail("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required for the twilio sms driver")
+		}
+	default:
+		fail("unknown SMS_DRIVER %q", c.SMS.Driver)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range c.OIDC {
+		if p.Name == "" {
+			fail("every oidc provider needs a name")
+		} else if seen[p.Name] {
+			fail("oidc provider %q is configured twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	if c.Production() && c.Exports.Dir == "" {
+		fail("EXPORT_DIR is required in production and must be shared by every instance")
+	}
+
+	if c.Accounts.DeletionGraceDays < 0 {
+		fail("ACCOUNT_DELETION_GRACE_DAYS must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		fail("unknown OTEL_TRACES_EXPORTER %q", c.Tracing.Exporter)
+	}
+
+	// Password settings are range-checked by passwords.New
+	return errors.Join(errs...)
+}
+
+// To check the database settings alone, for tools that need nothing else
+func (d DatabaseConfig) Validate() error {
+	var errs []error
+
+	if d.URL == "" && (d.Host == "" || d.User == "" || d.Name == "") {
+		errs = append(errs, errors.New("DB_HOST, DB_USER and DB_NAME are required unless DATABASE_URL is set"))
+	}
+	if d.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("DB_CONNECT_TIMEOUT must not be negative"))
+	}
+	if d.SlowQuery <= 0 {
+		errs = append(errs, errors.New("DB_SLOW_QUERY_MS must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+const redacted = "[REDACTED]"
+
+// To get a copy with every secret replaced, safe to print or log
+func (c Config) Redacted() Config {
+	hide := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err == nil {
+			c.Database.URL = u.Redacted()
+		} else {
+			c.Database.URL = redacted
+		}
+	}
+	hide(&c.Database.Password)
+	hide(&c.JWT.Secret)
+	hide(&c.Mail.SMTPPassword)
+	hide(&c.SMS.TwilioAuthToken)
+	hide(&c.Metrics.Token)
+
+	c.OIDC = append([]OIDCProvider(nil), c.OIDC...)
+	for i := range c.OIDC {
+		hide(&c.OIDC[i].ClientSecret)
+	}
+
+	return c
+}
+
+// To print the config as YAML with secrets redacted, also used by %v and %+v
+func (c Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(out)
+}
+
+// To keep %#v from printing secrets too
+func (c Config) GoString() string {
+	return c.String()
+}
+
+// redactedConfig has no methods, so slog encodes its fields instead of
+// calling LogValue again
+type redactedConfig Config
+
+// To log the config with secrets redacted
+func (c Config) LogValue() slog.Value {
+	return slog.AnyValue(redactedConfig(c.Redacted()))
+}
@@ -0,0 +1,9 @@
+// Package db embeds the SQL migrations so every binary carries them
+package db
+
+import "embed"
+
+// Migrations holds migrations/NNNNNN_name.{up,down}.sql
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,19 @@
+CREATE TABLE auth_throttles (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    last_failure_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE login_attempts (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(32) NOT NULL,
+    identifier VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    success BOOLEAN NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_identifier ON login_attempts(identifier);
+CREATE INDEX idx_login_attempts_created_at ON login_attempts(created_at);
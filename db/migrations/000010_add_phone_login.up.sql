@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN phone_e164 VARCHAR(20) UNIQUE;
+ALTER TABLE users ADD COLUMN phone_verified_at TIMESTAMP;
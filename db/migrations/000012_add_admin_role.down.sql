@@ -0,0 +1,16 @@
+-- The older constraint has no admin role, so admins go back to being
+-- customers rather than leaving the rollback stuck on their rows
+DO $$
+DECLARE
+    demoted INTEGER;
+BEGIN
+    UPDATE users SET role = 'customer' WHERE role = 'admin';
+    GET DIAGNOSTICS demoted = ROW_COUNT;
+
+    IF demoted > 0 THEN
+        RAISE NOTICE 'demoted % admin(s) to customer, promote them again after migrating back up', demoted;
+    END IF;
+END $$;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('stylist', 'customer'));
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_bookings_stylist_id;
+DROP INDEX IF EXISTS idx_bookings_user_id;
+
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_stylist_id_fkey;
+ALTER TABLE bookings ADD CONSTRAINT bookings_stylist_id_fkey FOREIGN KEY (stylist_id) REFERENCES users(id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_users_email;
+ALTER TABLE users DROP COLUMN IF EXISTS profile_picture;
+
+ALTER TABLE stylists RENAME COLUMN sample_of_services TO service_img;
@@ -0,0 +1,69 @@
+-- To match models.Stylist.SampleOfServices
+ALTER TABLE stylists RENAME COLUMN service_img TO sample_of_services;
+
+-- models.User has a profile picture too
+ALTER TABLE users ADD COLUMN profile_picture TEXT;
+
+-- One account per email whatever its case. Older databases may hold
+-- duplicates, Foo@x.com and foo@x.com included: the oldest account keeps the
+-- address and the others get it suffixed with their ID, so nothing is lost
+-- and support can merge them. Emails are then stored in lowercase, as the
+-- app writes and looks them up.
+DO $$
+DECLARE
+    renamed INTEGER;
+BEGIN
+    UPDATE users SET email = users.email || '.duplicate-' || users.id
+    FROM (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(email) ORDER BY id) AS n
+        FROM users
+    ) ranked
+    WHERE ranked.id = users.id AND ranked.n > 1;
+    GET DIAGNOSTICS renamed = ROW_COUNT;
+
+    IF renamed > 0 THEN
+        RAISE NOTICE 'renamed % user(s) whose email duplicated an older account, see emails ending in .duplicate-<id>', renamed;
+    END IF;
+END $$;
+
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+
+CREATE UNIQUE INDEX idx_users_email ON users(LOWER(email));
+
+-- bookings.stylist_id holds the stylist's user ID and must belong to a stylist
+-- profile, not just any user. fk_bookings_stylist is an inverted key an early
+-- AutoMigrate left on stylists in some databases.
+ALTER TABLE stylists DROP CONSTRAINT IF EXISTS fk_bookings_stylist;
+ALTER TABLE bookings DROP CONSTRAINT IF EXISTS bookings_stylist_id_fkey;
+
+-- Bookings made with a user that has no stylist profile keep their history
+-- under an inactive profile created for that user; bookings whose stylist
+-- user no longer exists at all have nothing to point at and are deleted.
+DO $$
+DECLARE
+    backfilled INTEGER;
+    deleted INTEGER;
+BEGIN
+    INSERT INTO stylists (stylist_id, active_status)
+    SELECT DISTINCT b.stylist_id, FALSE
+    FROM bookings b
+    JOIN users u ON u.id = b.stylist_id
+    WHERE NOT EXISTS (SELECT 1 FROM stylists s WHERE s.stylist_id = b.stylist_id);
+    GET DIAGNOSTICS backfilled = ROW_COUNT;
+
+    DELETE FROM bookings b
+    WHERE NOT EXISTS (SELECT 1 FROM stylists s WHERE s.stylist_id = b.stylist_id);
+    GET DIAGNOSTICS deleted = ROW_COUNT;
+
+    IF backfilled > 0 THEN
+        RAISE NOTICE 'created % inactive stylist profile(s) for users with bookings but no profile', backfilled;
+    END IF;
+    IF deleted > 0 THEN
+        RAISE NOTICE 'deleted % booking(s) whose stylist no longer exists', deleted;
+    END IF;
+END $$;
+
+ALTER TABLE bookings ADD CONSTRAINT bookings_stylist_id_fkey FOREIGN KEY (stylist_id) REFERENCES stylists(stylist_id) ON DELETE CASCADE;
+
+CREATE INDEX idx_bookings_user_id ON bookings(user_id);
+CREATE INDEX idx_bookings_stylist_id ON bookings(stylist_id);
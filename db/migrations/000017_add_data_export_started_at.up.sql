@@ -0,0 +1,4 @@
+ALTER TABLE data_exports ADD COLUMN started_at TIMESTAMP;
+
+-- Exports already being built count from when they were queued
+UPDATE data_exports SET started_at = created_at WHERE status = 'processing';
@@ -0,0 +1,72 @@
+// Package apperror holds errors that carry the HTTP response they should
+// produce. Handlers return them and the central error handler writes
+// {"error": message} with the matching status.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is an error meant to reach the client with Status and Message.
+// Err is the underlying cause, logged but never sent to the client.
+type Error struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// To create an error with any status
+func New(status int, message string) *Error {
+	return &Error{Status: status, Message: message}
+}
+
+// To wrap a cause, keeping it out of the response
+func Wrap(status int, message string, err error) *Error {
+	return &Error{Status: status, Message: message, Err: err}
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, message)
+}
+
+// To report a server-side failure, the cause is only logged
+func Internal(message string, err error) *Error {
+	return Wrap(http.StatusInternalServerError, message, err)
+}
+
+// To find the *Error in err's chain, if any
+func As(err error) (*Error, bool) {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/rbac"
+	"ezwait/internal/repository"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminHandler serves the staff endpoints
+type AdminHandler struct {
+	users     *services.UserService
+	stylists  *services.StylistService
+	twoFactor *services.TwoFactorService
+	validate  *validation.Validator
+}
+
+// To build an AdminHandler on the user, stylist and 2FA services
+func NewAdminHandler(users *services.UserService, stylists *services.StylistService, twoFactor *services.TwoFactorService, validate *validation.Validator) *AdminHandler {
+	return &AdminHandler{users: users, stylists: stylists, twoFactor: twoFactor, validate: validate}
+}
+
+func (h *AdminHandler) ListUsersHandler(c *fiber.Ctx) error {
+	roleFilter := c.Query("role")
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	users, err := h.users.List(c.UserContext(), roleFilter, repository.Page{Limit: limit, Offset: (page - 1) * limit})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	var response []fiber.Map
+	for _, u := range users {
+		response = append(response, fiber.Map{
+			"id":                 u.ID,
+			"name":               u.Name,
+			"email":              u.Email,
+			"number":             u.Number,
+			"role":               u.Role,
+			"location":           u.Location,
+			"email_verified":     u.EmailVerified,
+			"two_factor_enabled": u.TOTPEnabled,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Users retrieved successfully",
+		"data":    response,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *AdminHandler) UpdateUserRoleHandler(c *fiber.Ctx) error {
+	adminIDFloat, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+
+	userID, err := strconv.Atoi(c.Params("userId"))
+	if err != nil || userID < 1 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	// To stop admins from locking themselves out
+	if uint(userID) == uint(adminIDFloat) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "You cannot change your own role",
+		})
+	}
+
+	var input struct {
+		Role string `json:"role" validate:"required"`
+	}
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	user, err := h.users.ChangeRole(c.UserContext(), uint(userID), input.Role)
+	if errors.Is(err, services.ErrUnknownRole) {
+		return validation.Respond(c, validation.FieldError("role", validation.FieldInvalidChoice))
+	}
+	if errors.Is(err, services.ErrUserNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to update role",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Role updated successfully",
+		"data": fiber.Map{
+			"id":          user.ID,
+			"role":        input.Role,
+			"permissions": rbac.PermissionsFor(input.Role),
+		},
+	})
+}
+
+// To hide or re-list a stylist, e.g. after complaints
+func (h *AdminHandler) ModerateStylistHandler(c *fiber.Ctx) error {
+	stylistID, err := strconv.Atoi(c.Params("stylistId"))
+	if err != nil || stylistID < 1 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Stylist not found",
+		})
+	}
+
+	var input struct {
+		ActiveStatus *bool `json:"active_status" validate:"required"`
+	}
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	stylist, err := h.stylists.SetActive(c.UserContext(), uint(stylistID), *input.ActiveStatus)
+	if errors.Is(err, services.ErrStylistNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Stylist not found",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to update stylist",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Stylist updated successfully",
+		"data":    stylistResponse(stylist),
+	})
+}
+
+func (h *AdminHandler) SetTwoFactorPolicyHandler(c *fiber.Ctx) error {
+	role := c.Params("role")
+	if !rbac.IsRole(role) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Unknown role",
+		})
+	}
+
+	var input struct {
+		Required *bool `json:"required" validate:"required"`
+	}
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	if err := h.twoFactor.SetTwoFactorPolicy(c.UserContext(), role, *input.Required); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to update policy",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Two-factor policy updated successfully",
+		"data": fiber.Map{
+			"role":               role,
+			"require_two_factor": *input.Required,
+		},
+	})
+}
@@ -1,66 +1,120 @@
 package handlers
 
 import (
+	"context"
 	"errors"
-	"ezwait/config"
+	"ezwait/internal/jobs"
+	"ezwait/internal/metrics"
 	"ezwait/internal/models"
+	"ezwait/internal/passwords"
+	"ezwait/internal/services"
 	"ezwait/internal/utils"
-	"log"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/session"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
-var store = session.New()
+// RegisterInput is the body of a registration request
+type RegisterInput struct {
+	Name            string `json:"name" validate:"required,max=255"`
+	Email           string `json:"email" validate:"required,email,max=255"`
+	Number          string `json:"number" validate:"omitempty,phone"`
+	Role            string `json:"role" validate:"required,oneof=stylist customer"`
+	Password        string `json:"password" validate:"required"`
+	ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=Password"`
+	Location        string `json:"location" validate:"max=255"`
+	ProfilePicture  string `json:"profile_picture" validate:"omitempty,url"`
+}
 
-func RegisterHandler(c *fiber.Ctx) error {
-	var user models.User
+// AuthHandler serves registration, login and the account security endpoints
+type AuthHandler struct {
+	logins
+	users        *services.UserService
+	passwords    *services.PasswordService
+	verification *services.VerificationService
+	accounts     *services.AccountService
+	throttle     *services.ThrottleService
+	validate     *validation.Validator
+	tasks        *jobs.Tasks
+}
 
-	// To parse request
-	if err := c.BodyParser(&user); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+// To build an AuthHandler on the services it uses, emails sent after the
+// response run on tasks
+func NewAuthHandler(s *services.Services, validate *validation.Validator, tasks *jobs.Tasks) *AuthHandler {
+	return &AuthHandler{
+		logins:       newLogins(s),
+		users:        s.Users,
+		passwords:    s.Passwords,
+		verification: s.Verification,
+		accounts:     s.Accounts,
+		throttle:     s.Throttle,
+		validate:     validate,
+		tasks:        tasks,
 	}
+}
 
-	// To check if email exists
-	var existingUser models.User
+// logins finishes every way of logging in, asking for the second factor when
+// one applies and otherwise starting the session
+type logins struct {
+	sessions  *services.SessionService
+	twoFactor *services.TwoFactorService
+	keys      *utils.KeyRing
+}
 
-	db := config.DB
-	if db == nil {
-		log.Println("DB is nill")
-		return c.Status(fiber.StatusInternalServerError).JSON((fiber.Map{
-			"error": "Database connection not initialized",
-		}))
-	}
+func newLogins(s *services.Services) logins {
+	return logins{sessions: s.Sessions, twoFactor: s.TwoFactor, keys: s.Keys}
+}
 
-	if err := db.Where("email = ?", user.Email).First(&existingUser).Error; err == nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Email already registered"})
-	}
+func (h *AuthHandler) RegisterHandler(c *fiber.Ctx) error {
+	var input RegisterInput
 
-	// To validate role
-	if user.Role != models.RoleStylist && user.Role != models.RoleCustomer {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid role"})
+	// To parse and validate the request
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	// To validate passwords
-	if user.Password != user.ConfirmPassword {
-		return c.Status(400).JSON(fiber.Map{"error": "Passwords do not match"})
+	// To check the password policy, length and breached passwords included
+	if err := h.passwords.Check(input.Password, input.Email, input.Name); err != nil {
+		return validation.Respond(c, validation.Password("password", err))
 	}
 
 	// To hash password
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	user.Password = string(hashedPassword)
+	hashedPassword, err := h.passwords.Hash(input.Password)
+	if err != nil {
+		var policyErr *passwords.PolicyError
+		if errors.As(err, &policyErr) {
+			return validation.Respond(c, validation.Password("password", err))
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to register user"})
+	}
 
-	// To save user
-	if err := config.DB.Create(&user).Error; err != nil {
+	// To build the user from the validated fields only, so the email starts
+	// unverified and 2FA off whatever else the body contains
+	user := models.User{
+		Name:           input.Name,
+		Email:          input.Email,
+		Number:         input.Number,
+		Role:           input.Role,
+		Password:       hashedPassword,
+		Location:       input.Location,
+		ProfilePicture: input.ProfilePicture,
+	}
+
+	// To save the user and send the verification code
+	err = h.users.Register(c.UserContext(), &user)
+	if errors.Is(err, services.ErrEmailTaken) {
+		return validation.Respond(c, validation.FieldError("email", validation.FieldTaken))
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to register user",
 		})
 	}
 
 	return c.Status(201).JSON(fiber.Map{
-		"message": "User created successfully",
+		"message": "User created successfully, check your email for a verification code",
 		"data": fiber.Map{
 			"id":              user.ID,
 			"name":            user.Name,
@@ -69,83 +123,247 @@ func RegisterHandler(c *fiber.Ctx) error {
 			"role":            user.Role,
 			"location":        user.Location,
 			"profile_picture": user.ProfilePicture,
+			"email_verified":  user.EmailVerified,
 		},
 	})
 }
 
-func LoginHandler(c *fiber.Ctx) error {
+func (h *AuthHandler) LoginHandler(c *fiber.Ctx) error {
 	// Login form request structure
 	type LoginRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required,max=256"`
 	}
 
 	var loginReq LoginRequest
 
 	// To parse request
-	if err := c.BodyParser(&loginReq); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	if err := h.validate.ParseBody(c, &loginReq); err != nil {
+		return validation.Respond(c, err)
 	}
 
 	// To find user by email
-	var user models.User
+	user, err := h.users.FindByEmail(c.UserContext(), loginReq.Email)
+	if errors.Is(err, services.ErrUserNotFound) {
+		metrics.Login("password", false)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid Credentials"})
+	}
 
-	db := config.DB
-	if db == nil {
-		log.Println("DB is nill")
-		return c.Status(fiber.StatusInternalServerError).JSON((fiber.Map{
-			"error": "Database connection not initialized",
-		}))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 
-	err := db.Where("email = ?", loginReq.Email).First(&user).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	// To compare hashed password
+	match, err := h.passwords.Verify(user.Password, loginReq.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check password"})
+	}
+	if !match {
+		metrics.Login("password", false)
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid Credentials"})
 	}
 
+	metrics.Login("password", true)
+
+	// To move the hash to the configured algorithm or cost, the old one still works if this fails
+	if err := h.passwords.UpgradePasswordHash(c.UserContext(), user, loginReq.Password); err != nil {
+		logger.Error(c.UserContext(), "Failed to rehash password", "user_id", user.ID, "error", err)
+	}
+
+	return h.completeLogin(c, user)
+}
+
+// To finish a login once the first factor has been checked, either by issuing
+// a session or, when 2FA applies, a short-lived challenge token
+func (l logins) completeLogin(c *fiber.Ctx, user *models.User) error {
+	if user.TOTPEnabled {
+		challenge, err := l.keys.GenerateChallengeToken(user, utils.TokenTypeTwoFactorLogin)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
+		}
+
+		return c.Status(200).JSON(fiber.Map{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+	}
+
+	required, err := l.twoFactor.TwoFactorRequired(c.UserContext(), user.Role)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 
-	// To compare hashed password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password)); err != nil {
-		return c.Status(401).JSON(fiber.Map{"error": "Invalid Credentials"})
+	if required {
+		challenge, err := l.keys.GenerateChallengeToken(user, utils.TokenTypeTwoFactorEnroll)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
+		}
+
+		return c.Status(200).JSON(fiber.Map{
+			"message":                   "Two-factor authentication must be set up before you can continue",
+			"two_factor_setup_required": true,
+			"challenge_token":           challenge,
+		})
 	}
 
-	// To generate JWT token
-	token, err := utils.GenerateToken(&user)
+	return l.issueSession(c, user, fiber.Map{"message": "Login successful"})
+}
+
+// To start a new session for the user and respond with its tokens added to
+// body, which holds the message and anything else the caller returns
+func (l logins) issueSession(c *fiber.Ctx, user *models.User, body fiber.Map) error {
+	// To offer a restore instead of a session while the account waits to be deleted
+	if user.DeletionRequestedAt != nil {
+		return l.deletionScheduled(c, user)
+	}
+
+	// To generate the access and refresh tokens
+	tokens, err := l.sessions.IssueTokenPair(c.UserContext(), user, sessionDevice(c))
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
 
 	// Return response
+	body["token"] = tokens.AccessToken
+	body["refresh_token"] = tokens.RefreshToken
+	body["expires_in"] = tokens.ExpiresIn
+	body["session_id"] = tokens.SessionID
+	body["data"] = fiber.Map{
+		"id":                 user.ID,
+		"name":               user.Name,
+		"email":              user.Email,
+		"number":             user.Number,
+		"role":               user.Role,
+		"location":           user.Location,
+		"profile_picture":    user.ProfilePicture,
+		"email_verified":     user.EmailVerified,
+		"two_factor_enabled": user.TOTPEnabled,
+	}
+
+	return c.Status(200).JSON(body)
+}
+
+// To refuse a session to an account scheduled for deletion, with a token to restore it
+func (l logins) deletionScheduled(c *fiber.Ctx, user *models.User) error {
+	restoreToken, err := l.keys.GenerateChallengeToken(user, utils.TokenTypeAccountRestore)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
+	}
+
+	return c.Status(403).JSON(fiber.Map{
+		"error":                 "This account is scheduled for deletion",
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+		"restore_token":         restoreToken,
+	})
+}
+
+func (h *AuthHandler) VerifyEmailHandler(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email" validate:"required,email"`
+		Code  string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	user, err := h.users.FindByEmail(c.UserContext(), input.Email)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired code"})
+	}
+
+	err = h.verification.VerifyEmail(c.UserContext(), user, input.Code)
+	if errors.Is(err, services.ErrAlreadyVerified) {
+		return c.Status(200).JSON(fiber.Map{"message": "Email is already verified"})
+	}
+
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTooManyAttempts) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify email"})
+	}
+
 	return c.Status(200).JSON(fiber.Map{
-		"message": "Login successful",
-		"token":   token,
-		"data": fiber.Map{
-			"id":              user.ID,
-			"name":            user.Name,
-			"email":           user.Email,
-			"number":          user.Number,
-			"role":            user.Role,
-			"location":        user.Location,
-			"profile_picture": user.ProfilePicture,
-		},
+		"message": "Email verified successfully",
 	})
 }
 
-func LogoutHandler(c *fiber.Ctx) error {
-	// To retrieve the session
-	session, err := store.Get(c)
+func (h *AuthHandler) ResendVerificationHandler(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	// To respond the same way for unknown or verified emails so accounts can't be probed
+	response := fiber.Map{
+		"message": "If the account exists and is unverified, a new code has been sent",
+	}
+
+	user, err := h.users.FindByEmail(c.UserContext(), input.Email)
+	if err != nil {
+		return c.Status(200).JSON(response)
+	}
+
+	err = h.verification.SendEmailVerification(c.UserContext(), user)
+
+	var resendErr *services.ResendError
+	if errors.As(err, &resendErr) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(resendErr.RetryAfter.Seconds())+1))
+		return c.Status(429).JSON(fiber.Map{"error": resendErr.Error()})
+	}
+
+	if err != nil && !errors.Is(err, services.ErrAlreadyVerified) {
+		logger.Error(c.UserContext(), "Failed to resend verification email", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send verification code"})
+	}
+
+	return c.Status(200).JSON(response)
+}
+
+func (h *AuthHandler) RefreshTokenHandler(c *fiber.Ctx) error {
+	var input struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	tokens, err := h.sessions.RotateRefreshToken(c.UserContext(), input.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to refresh token"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":       "Token refreshed successfully",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+func (h *AuthHandler) LogoutHandler(c *fiber.Ctx) error {
+	sessionID, ok := c.Locals("session_id").(string)
+	if !ok || sessionID == "" {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Unauthorized user",
 		})
 	}
-	// To destroy the session
-	if err := session.Destroy(); err != nil {
-		c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
+
+	// To revoke the refresh token family and the access tokens issued from it
+	if err := h.sessions.RevokeFamily(c.UserContext(), sessionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to log out",
 		})
 	}
 
@@ -154,7 +372,7 @@ func LogoutHandler(c *fiber.Ctx) error {
 	})
 }
 
-func ChangePassword(c *fiber.Ctx) error {
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
 	userIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{
@@ -165,56 +383,154 @@ func ChangePassword(c *fiber.Ctx) error {
 	userID := uint(userIDFloat)
 
 	var input struct {
-		CurrentPassword string `json:"current_password"`
-		NewPassword     string `json:"new_password"`
-		ConfirmPassword string `json:"confirm_password"`
+		CurrentPassword string `json:"current_password" validate:"required,max=256"`
+		NewPassword     string `json:"new_password" validate:"required"`
+		ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=NewPassword"`
 	}
 
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	if input.NewPassword != input.ConfirmPassword {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "New passwords do not match",
-		})
-	}
-
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
+	user, err := h.users.Get(c.UserContext(), userID)
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "User not found",
 		})
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+	match, err := h.passwords.Verify(user.Password, input.CurrentPassword)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to check password",
+		})
+	}
+	if !match {
 		return c.Status(401).JSON(fiber.Map{
 			"error": "Current password is incorrect",
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err := h.passwords.Check(input.NewPassword, user.Email, user.Name); err != nil {
+		return validation.Respond(c, validation.Password("new_password", err))
+	}
+
+	hashedPassword, err := h.passwords.Hash(input.NewPassword)
 	if err != nil {
+		var policyErr *passwords.PolicyError
+		if errors.As(err, &policyErr) {
+			return validation.Respond(c, validation.Password("new_password", err))
+		}
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to hash new password",
 		})
 	}
 
-	user.Password = string(hashedPassword)
-	if err := config.DB.Save(&user).Error; err != nil {
+	if err := h.users.SetPassword(c.UserContext(), user, hashedPassword); err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to update password",
 		})
 	}
 
 	return c.Status(200).JSON(fiber.Map{
-		"message": "Password updated successfully",
+		"message": "Password updated successfully, please log in again",
+	})
+}
+
+func (h *AuthHandler) ForgotPasswordHandler(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	// To process the request in the background so the response and its timing
+	// are the same whether or not the email exists
+	h.tasks.Go(c.UserContext(), "password-reset-request", func(ctx context.Context) error {
+		return h.passwords.RequestPasswordReset(ctx, input.Email)
+	})
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+func (h *AuthHandler) ResetPasswordHandler(c *fiber.Ctx) error {
+	var input struct {
+		Token           string `json:"token" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required"`
+		ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=NewPassword"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err := h.passwords.ResetPassword(c.UserContext(), input.Token, input.NewPassword)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+	}
+
+	var policyErr *passwords.PolicyError
+	if errors.As(err, &policyErr) {
+		return validation.Respond(c, validation.Password("new_password", err))
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Password reset successfully, please log in with your new password",
+	})
+}
+
+func (h *AuthHandler) RequestUnlockHandler(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	h.tasks.Go(c.UserContext(), "unlock-request", func(ctx context.Context) error {
+		return h.throttle.RequestAccountUnlock(ctx, input.Email)
+	})
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "If this account is locked, an unlock code has been sent to its email",
+	})
+}
+
+func (h *AuthHandler) UnlockAccountHandler(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email" validate:"required,email"`
+		Code  string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err := h.throttle.UnlockAccount(c.UserContext(), input.Email, input.Code)
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTooManyAttempts) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unlock account"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Account unlocked, you can log in again",
 	})
 }
 
-func DeleteAccount(c *fiber.Ctx) error {
+// To schedule the account for deletion, it can be restored by logging in during the grace period
+func (h *AuthHandler) DeleteAccount(c *fiber.Ctx) error {
 	userIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{
@@ -223,15 +539,69 @@ func DeleteAccount(c *fiber.Ctx) error {
 	}
 	userID := uint(userIDFloat)
 
-	var user models.User
+	user, err := h.users.Get(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	err = h.accounts.RequestAccountDeletion(c.UserContext(), user)
+	if errors.Is(err, services.ErrDeletionAlreadyRequested) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	if err := config.DB.Delete(&user, userID).Error; err != nil {
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to delete account: " + err.Error(),
+			"error": "Failed to delete account",
 		})
 	}
 
 	return c.Status(200).JSON(fiber.Map{
-		"message": "Your account has been deleted successfully",
+		"message":               "Your account is scheduled for deletion, log in before the date below to restore it",
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+	})
+}
+
+// To restore an account scheduled for deletion, using the token returned by login
+func (h *AuthHandler) RestoreAccountHandler(c *fiber.Ctx) error {
+	var input struct {
+		RestoreToken string `json:"restore_token" validate:"required"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	userID, err := h.keys.VerifyChallengeToken(input.RestoreToken, utils.TokenTypeAccountRestore)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired restore token"})
+	}
+
+	err = h.accounts.CancelAccountDeletion(c.UserContext(), userID)
+	if errors.Is(err, services.ErrNoDeletionPending) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore account"})
+	}
+
+	user, err := h.users.Get(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore account"})
+	}
+
+	return h.issueSession(c, user, fiber.Map{"message": "Your account has been restored"})
+}
+
+// To publish the public keys used to verify access tokens
+func (h *AuthHandler) JWKSHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+
+	return c.Status(200).JSON(fiber.Map{
+		"keys": h.keys.JWKS(),
 	})
 }
@@ -1,16 +1,30 @@
 package handlers
 
 import (
-	"ezwait/config"
+	"errors"
+	"ezwait/internal/apperror"
 	"ezwait/internal/models"
-	"fmt"
+	"ezwait/internal/repository"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func MakeBooking(c *fiber.Ctx) error {
+// BookingHandler serves the booking endpoints
+type BookingHandler struct {
+	bookings *services.BookingService
+	validate *validation.Validator
+}
+
+// To build a BookingHandler on the booking service
+func NewBookingHandler(bookings *services.BookingService, validate *validation.Validator) *BookingHandler {
+	return &BookingHandler{bookings: bookings, validate: validate}
+}
+
+func (h *BookingHandler) MakeBooking(c *fiber.Ctx) error {
 	customerIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(500).JSON(fiber.Map{
@@ -21,90 +35,45 @@ func MakeBooking(c *fiber.Ctx) error {
 
 	// To parse the booking request body
 	var input struct {
-		StylistID  uint      `json:"stylist_id"`
-		StartTime  time.Time `json:"start_time"`
-		EndTime    time.Time `json:"end_time"`
-		BookingDay string    `json:"booking_day"`
-	}
-
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
+		StylistID  uint      `json:"stylist_id" validate:"required"`
+		StartTime  time.Time `json:"start_time" validate:"required"`
+		EndTime    time.Time `json:"end_time" validate:"required,gtfield=StartTime"`
+		BookingDay string    `json:"booking_day" validate:"required,datetime=2006-01-02"`
 	}
 
-	// To check if the stylist exist
-	var stylist models.Stylist
-	if err := config.DB.Where("stylist_id = ?", input.StylistID).First(&stylist).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Stylist not found: " + err.Error(),
-		})
-	}
-
-	// To know if the time slot is available for bookings
-	var count int64
-	config.DB.Model(&models.Booking{}).Where(
-		"stylist_id = ? AND booking_day = ? AND (start_time < ? AND end_time > ?)",
-		input.StylistID, input.BookingDay, input.EndTime, input.StartTime,
-	).Count(&count)
-
-	if count > 0 {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Time slot already booked",
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	// To handle the booking day format
-	bookingDay, err := time.Parse("2006-01-02", input.BookingDay)
+	slot, err := bookingSlot(input.BookingDay, input.StartTime, input.EndTime)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid date format" + err.Error(),
-		})
-	}
-
-	// To handle auto confirm settings
-	bookingStatus := "pending"
-	if stylist.AutoConfirm {
-		bookingStatus = "confirmed"
-	}
-
-	// To create the booking with "pending" status
-	booking := models.Booking{
-		UserID:        customerID,
-		StylistID:     input.StylistID,
-		StartTime:     input.StartTime,
-		EndTime:       input.EndTime,
-		BookingDay:    bookingDay,
-		BookingStatus: bookingStatus,
-		CreatedAt:     time.Now(),
+		return validation.Respond(c, err)
 	}
 
-	if err := config.DB.Create(&booking).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to create booking: " + err.Error(),
-		})
+	booking, err := h.bookings.Create(c.UserContext(), customerID, input.StylistID, slot)
+	if err != nil {
+		return bookingError(c, err, "Failed to create booking")
 	}
 
-	// To increase the no of active bookings for the stylist
-	config.DB.Model(&stylist).Update("no_of_customer_bookings", stylist.NoOfCustomerBookings+1)
-
 	return c.Status(201).JSON(fiber.Map{
 		"message": "Booking created successfully",
-		"data":    booking,
+		"data":    bookingResponse(booking),
 	})
 }
 
-func ViewAllBookings(c *fiber.Ctx) error {
+func (h *BookingHandler) ViewAllBookings(c *fiber.Ctx) error {
 	userIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Invalid user ID format",
-		})
+		return apperror.Unauthorized("Unauthorized user")
 	}
 
 	userID := uint(userIDFloat)
 
-	role := c.Locals("role").(string)
+	role, ok := c.Locals("role").(string)
+	if !ok {
+		return apperror.Unauthorized("Unauthorized user")
+	}
+
 	// To query params for filtering
 	statusFilter := c.Query("status")
 	page, _ := strconv.Atoi(c.Query("page", "1"))
@@ -118,51 +87,15 @@ func ViewAllBookings(c *fiber.Ctx) error {
 		limit = 10
 	}
 
-	offset := (page - 1) * limit
-
-	var bookings []models.Booking
-	query := config.DB.Model(&models.Booking{}).Preload("User").Preload("Stylist")
-
-	if role == "customer" {
-		query = query.Where("user_id = ?", userID)
-	} else if role == "stylist" {
-		query = query.Where("stylist_id = ?", userID)
-	}
-
-	if statusFilter != "" {
-		query = query.Where("booking_status = ?", statusFilter)
-	}
-
-	if err := query.Offset(offset).Limit(limit).Find(&bookings).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to fetch bookings: " + err.Error(),
-		})
+	bookings, err := h.bookings.List(c.UserContext(), userID, role, statusFilter, repository.Page{Limit: limit, Offset: (page - 1) * limit})
+	if err != nil {
+		return apperror.Internal("Failed to fetch bookings", err)
 	}
 
 	// Response including user & stylist details
 	var response []fiber.Map
-	for _, b := range bookings {
-		response = append(response, fiber.Map{
-			"id":             b.ID,
-			"start_time":     b.StartTime,
-			"end_time":       b.EndTime,
-			"booking_day":    b.BookingDay,
-			"booking_status": b.BookingStatus,
-			"created_at":     b.CreatedAt,
-			"user": fiber.Map{
-				"id":       b.User.ID,
-				"name":     b.User.Name,
-				"email":    b.User.Email,
-				"number":   b.User.Number,
-				"location": b.User.Location,
-			},
-			"stylist": fiber.Map{
-				"id":              b.Stylist.ID,
-				"stylist_id":      b.Stylist.StylistID,
-				"profile_picture": b.Stylist.ProfilePicture,
-				"ratings":         b.Stylist.Ratings,
-			},
-		})
+	for i := range bookings {
+		response = append(response, bookingResponse(&bookings[i]))
 	}
 
 	return c.Status(200).JSON(fiber.Map{
@@ -173,42 +106,35 @@ func ViewAllBookings(c *fiber.Ctx) error {
 	})
 }
 
-func ViewSingleBooking(c *fiber.Ctx) error {
-	bookingID := c.Params("bookingId")
-	var booking models.Booking
-	err := config.DB.Preload("User").Preload("Stylist").Where("id = ?", bookingID).First(&booking).Error
-	if err != nil {
+func (h *BookingHandler) ViewSingleBooking(c *fiber.Ctx) error {
+	userIDFloat, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Invalid user ID format",
+		})
+	}
+	userID := uint(userIDFloat)
+	role, _ := c.Locals("role").(string)
+
+	bookingID, err := strconv.Atoi(c.Params("bookingId"))
+	if err != nil || bookingID < 1 {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Booking not found",
 		})
 	}
 
+	booking, err := h.bookings.Get(c.UserContext(), uint(bookingID), userID, role)
+	if err != nil {
+		return bookingError(c, err, "Failed to fetch booking")
+	}
+
 	return c.JSON(fiber.Map{
 		"message": "Booking retrieved successfully",
-		"data": fiber.Map{
-			"id": booking.ID,
-			"user": fiber.Map{
-				"id":       booking.User.ID,
-				"name":     booking.User.Name,
-				"email":    booking.User.Email,
-				"number":   booking.User.Number,
-				"location": booking.User.Location,
-			},
-			"stylist": fiber.Map{
-				"id":              booking.Stylist.ID,
-				"profile_picture": booking.Stylist.ProfilePicture,
-				"ratings":         booking.Stylist.Ratings,
-			},
-			"start_time":     booking.StartTime,
-			"end_time":       booking.EndTime,
-			"booking_day":    booking.BookingDay,
-			"booking_status": booking.BookingStatus,
-			"created_at":     booking.CreatedAt,
-		},
+		"data":    bookingResponse(booking),
 	})
 }
 
-func EditBooking(c *fiber.Ctx) error {
+func (h *BookingHandler) EditBooking(c *fiber.Ctx) error {
 	customerIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(500).JSON(fiber.Map{
@@ -218,186 +144,148 @@ func EditBooking(c *fiber.Ctx) error {
 	customerID := uint(customerIDFloat)
 
 	// To get the booking ID from the req params
-	bookingIDStr := c.Params("bookingId")
-	// fmt.Println("Raw booking ID string from request:", bookingIDStr)
-
-	bookingID, err := strconv.Atoi(bookingIDStr)
+	bookingID, err := strconv.Atoi(c.Params("bookingId"))
 	if err != nil || bookingID < 1 {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid booking ID: " + err.Error(),
-		})
-	}
-
-	// fmt.Println("Extracted booking ID:", bookingID)
-
-	// To fetch the booking form the DB
-	var booking models.Booking
-	if err := config.DB.Where("id = ?", bookingID).First(&booking).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Booking not found: " + err.Error(),
-		})
-	}
-
-	// To check if the bookings belong to the authenticated user
-	if booking.UserID != customerID {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "You are not authorized to edit this booking",
-		})
-	}
-
-	if booking.BookingStatus != "pending" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "You can only edit a pending bookings",
+			"error": "Invalid booking ID",
 		})
 	}
 
 	// To parse the req body
 	var input struct {
-		StartTime  time.Time `json:"start_time"`
-		EndTime    time.Time `json:"end_time"`
-		BookingDay string    `json:"booking_day"`
+		StartTime  time.Time `json:"start_time" validate:"required"`
+		EndTime    time.Time `json:"end_time" validate:"required,gtfield=StartTime"`
+		BookingDay string    `json:"booking_day" validate:"required,datetime=2006-01-02"`
 	}
 
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	// To convert the booking day to the correct format
-	bookingDay, err := time.Parse("2006-01-02", input.BookingDay)
+	slot, err := bookingSlot(input.BookingDay, input.StartTime, input.EndTime)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid booking day format" + err.Error(),
-		})
-	}
-
-	// To check if the new time slot is avaliable
-	var count int64
-	config.DB.Model(&models.Booking{}).Where(
-		"stylist_id = ? AND booking_day = ? AND (start_time < ? AND end_time > ?)",
-		booking.StylistID, bookingDay, input.EndTime, input.StartTime,
-	).Count(&count)
-
-	if count > 0 {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Time slot already booked",
-		})
+		return validation.Respond(c, err)
 	}
 
-	// To update the booking details
-	booking.StartTime = input.StartTime
-	booking.EndTime = input.EndTime
-	booking.BookingDay = bookingDay
-
-	if err := config.DB.Save(&booking).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to update booking" + err.Error(),
-		})
+	booking, err := h.bookings.Reschedule(c.UserContext(), uint(bookingID), customerID, slot)
+	if err != nil {
+		return bookingError(c, err, "Failed to update booking")
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"message": "Booking updated successfully",
-		"data":    booking,
+		"data":    bookingResponse(booking),
 	})
 }
 
-func UpdateBookingStatus(c *fiber.Ctx) error {
-	stylistIDFloat, ok := c.Locals("user").(float64)
+func (h *BookingHandler) UpdateBookingStatus(c *fiber.Ctx) error {
+	userIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Invalid user ID format",
 		})
 	}
-	stylistID := uint(stylistIDFloat)
+	userID := uint(userIDFloat)
+	role, _ := c.Locals("role").(string)
 
 	// To get booking ID from Params
-	bookingIDStr := c.Params("bookingId")
-
-	bookingID, err := strconv.Atoi(bookingIDStr)
+	bookingID, err := strconv.Atoi(c.Params("bookingId"))
 	if err != nil || bookingID < 1 {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid booking ID: " + err.Error(),
+			"error": "Invalid booking ID",
 		})
 	}
 
 	// To get the new status from the req body
 	var input struct {
-		NewStatus string `json:"new_status"`
-	}
-
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
-	}
-
-	allowedStatuses := map[string]bool{
-		"pending":   true,
-		"confirmed": true,
-		"completed": true,
-		"cancelled": true,
+		NewStatus string `json:"new_status" validate:"required,oneof=pending confirmed completed cancelled"`
 	}
 
-	if !allowedStatuses[input.NewStatus] {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid booking status",
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	// To etch the booking from the DB
-	var booking models.Booking
-	if err := config.DB.Where("id = ?", bookingID).First(&booking).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Booking not found" + err.Error(),
-		})
-	}
-
-	// To check if the stylist is the owner of this booking
-	if booking.StylistID != stylistID {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "You are not authorized to update this booking",
-		})
-	}
-
-	// To prevent updates on completed/cancelled bookings
-	if booking.BookingStatus == "completed" || booking.BookingStatus == "cancelled" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "This booking cannot be updated",
-		})
-	}
-
-	// To update the booking status
-	booking.BookingStatus = input.NewStatus
-	if err := config.DB.Save(&booking).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to update booking status",
-		})
+	booking, err := h.bookings.UpdateStatus(c.UserContext(), uint(bookingID), userID, role, input.NewStatus)
+	if err != nil {
+		return bookingError(c, err, "Failed to update booking status")
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"message": "Booking status updated successfully",
-		"data":    booking,
+		"data":    bookingResponse(booking),
 	})
 }
 
-// To check for expired bookings and automatically mark them as completed
-func MarkCompletedBookings() {
-	var expiredBookings []models.Booking
-	// To find booking where end_time has passed and status is still "confirmed"
-	if err := config.DB.Where("end_time < ? AND booking_status = ?", time.Now(), "confirmed").Find(&expiredBookings).Error; err != nil {
-		fmt.Println("Error fetching expired bookings: ", err)
-		return
+// To describe a booking with the customer's contact details and the
+// stylist's public profile, never the models themselves, which hold the
+// password hash and everything else on the accounts
+func bookingResponse(b *models.Booking) fiber.Map {
+	response := fiber.Map{
+		"id":             b.ID,
+		"user_id":        b.UserID,
+		"stylist_id":     b.StylistID,
+		"start_time":     b.StartTime,
+		"end_time":       b.EndTime,
+		"booking_day":    b.BookingDay,
+		"booking_status": b.BookingStatus,
+		"created_at":     b.CreatedAt,
+	}
+
+	// A booking just made comes without them
+	if b.User.ID != 0 {
+		response["user"] = fiber.Map{
+			"id":       b.User.ID,
+			"name":     b.User.Name,
+			"email":    b.User.Email,
+			"number":   b.User.Number,
+			"location": b.User.Location,
+		}
+	}
+	if b.Stylist.ID != 0 {
+		response["stylist"] = fiber.Map{
+			"id":              b.Stylist.ID,
+			"stylist_id":      b.Stylist.StylistID,
+			"profile_picture": b.Stylist.ProfilePicture,
+			"ratings":         b.Stylist.Ratings,
+		}
+	}
+
+	return response
+}
+
+// To turn the booking day and times of a request into a slot in the future
+func bookingSlot(day string, start, end time.Time) (services.BookingSlot, error) {
+	if !start.After(time.Now()) {
+		return services.BookingSlot{}, validation.FieldError("start_time", validation.FieldMustBeFuture)
 	}
 
-	// To update each expired booking
-	for _, booking := range expiredBookings {
-		booking.BookingStatus = "completed"
-		config.DB.Save(&booking)
-		fmt.Println("Booking marked as completed: ", booking.ID)
+	bookingDay, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return services.BookingSlot{}, validation.FieldError("booking_day", validation.FieldInvalidDate)
 	}
+
+	return services.BookingSlot{Day: bookingDay, Start: start, End: end}, nil
 }
 
-func UpdateCurrentCustomers() {
-	return
+// To answer a booking service error, failure is the message for unexpected ones
+func bookingError(c *fiber.Ctx, err error, failure string) error {
+	var transition *services.TransitionError
+	switch {
+	case errors.Is(err, services.ErrStylistNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Stylist not found"})
+	case errors.Is(err, services.ErrBookingNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Booking not found"})
+	case errors.Is(err, services.ErrSlotTaken):
+		return c.Status(400).JSON(fiber.Map{"error": "Time slot already booked"})
+	case errors.Is(err, services.ErrNotBookingOwner):
+		return c.Status(403).JSON(fiber.Map{"error": "You are not authorized to change this booking"})
+	case errors.Is(err, services.ErrBookingNotEditable):
+		return c.Status(400).JSON(fiber.Map{"error": "You can only edit a pending bookings"})
+	case errors.Is(err, services.ErrBookingClosed):
+		return c.Status(400).JSON(fiber.Map{"error": "This booking cannot be updated"})
+	case errors.As(err, &transition):
+		return c.Status(400).JSON(fiber.Map{"error": "A " + transition.From + " booking cannot be " + transition.To})
+	}
+
+	return apperror.Internal(failure, err)
 }
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/models"
+	"ezwait/internal/services"
+	"ezwait/pkg/logger"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExportHandler serves personal data exports
+type ExportHandler struct {
+	exports *services.ExportService
+}
+
+// To build an ExportHandler on the export service
+func NewExportHandler(exports *services.ExportService) *ExportHandler {
+	return &ExportHandler{exports: exports}
+}
+
+// To download a copy of the user's personal data. Small accounts get it right
+// away, as JSON or with ?format=zip, large ones get it built in the background.
+func (h *ExportHandler) ExportDataHandler(c *fiber.Ctx) error {
+	userIDFloat, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+	userID := uint(userIDFloat)
+
+	format := c.Query("format", "json")
+	if format != "json" && format != "zip" {
+		return c.Status(400).JSON(fiber.Map{"error": "Format must be json or zip"})
+	}
+
+	size, err := h.exports.ExportSize(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to export data"})
+	}
+
+	if size > services.ExportSyncLimit {
+		export, err := h.exports.RequestDataExport(c.UserContext(), userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to export data"})
+		}
+
+		return c.Status(202).JSON(fiber.Map{
+			"message": "Your export is being prepared, you will be notified when it is ready",
+			"data":    h.exportResponse(c, export),
+		})
+	}
+
+	archive, err := h.exports.BuildExport(c.UserContext(), userID)
+	if err != nil {
+		logger.Error(c.UserContext(), "Failed to build data export", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to export data"})
+	}
+
+	filename := "ezwait-export-" + time.Now().Format("2006-01-02")
+	if format == "zip" {
+		c.Attachment(filename + ".zip")
+		c.Set(fiber.HeaderContentType, "application/zip")
+		return services.WriteExportZip(c.Response().BodyWriter(), archive)
+	}
+
+	c.Attachment(filename + ".json")
+	return c.Status(200).JSON(archive)
+}
+
+func (h *ExportHandler) DataExportStatusHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	exportID, err := strconv.Atoi(c.Params("exportId"))
+	if err != nil || exportID < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid export ID"})
+	}
+
+	export, err := h.exports.GetDataExport(c.UserContext(), uint(userID), uint(exportID))
+	if errors.Is(err, services.ErrExportNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Export not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch export"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Export retrieved successfully",
+		"data":    h.exportResponse(c, export),
+	})
+}
+
+// To serve a ready export through its signed, expiring link
+func (h *ExportHandler) DownloadDataExportHandler(c *fiber.Ctx) error {
+	export, err := h.exports.OpenDataExport(c.UserContext(), c.Query("token"))
+	if errors.Is(err, services.ErrExportNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "This download link is invalid or has expired"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to download export"})
+	}
+
+	return c.Download(export.FilePath, "ezwait-export-"+export.CreatedAt.Format("2006-01-02")+".zip")
+}
+
+func (h *ExportHandler) exportResponse(c *fiber.Ctx, export *models.DataExport) fiber.Map {
+	response := fiber.Map{
+		"id":           export.ID,
+		"status":       export.Status,
+		"size_bytes":   export.SizeBytes,
+		"created_at":   export.CreatedAt,
+		"completed_at": export.CompletedAt,
+		"expires_at":   export.ExpiresAt,
+	}
+
+	if token, err := h.exports.DataExportDownloadToken(export); err == nil {
+		response["download_url"] = c.BaseURL() + "/api/v1/user/export/download?token=" + token
+	}
+
+	return response
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"ezwait/internal/health"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var startedAt = time.Now()
+
+// To tell a liveness probe the process is up, without touching dependencies
+func LivenessHandler(c *fiber.Ctx) error {
+	return c.Status(200).JSON(fiber.Map{
+		"status":         health.StatusOK,
+		"uptime_seconds": int(time.Since(startedAt).Seconds()),
+	})
+}
+
+// HealthHandler serves the readiness probe
+type HealthHandler struct {
+	checks *health.Registry
+}
+
+// To build a HealthHandler running the checks of the registry
+func NewHealthHandler(checks *health.Registry) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// To tell a readiness probe whether the database, migrations and background
+// jobs are in a state to serve traffic, with the details of each check
+func (h *HealthHandler) ReadinessHandler(c *fiber.Ctx) error {
+	results, ready := h.checks.Run(c.UserContext())
+
+	if !ready {
+		return c.Status(503).JSON(fiber.Map{
+			"status": health.StatusFail,
+			"checks": results,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": health.StatusOK,
+		"checks": results,
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"ezwait/internal/services"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NotificationHandler serves a user's in-app notifications
+type NotificationHandler struct {
+	notifications *services.NotificationService
+}
+
+// To build a NotificationHandler on the notification service
+func NewNotificationHandler(notifications *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{notifications: notifications}
+}
+
+func (h *NotificationHandler) ListNotificationsHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	notifications, err := h.notifications.ListNotifications(c.UserContext(), uint(userID), limit, (page-1)*limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notifications"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Notifications retrieved successfully",
+		"data":    notifications,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *NotificationHandler) MarkNotificationReadHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	notificationID, err := strconv.Atoi(c.Params("notificationId"))
+	if err != nil || notificationID < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid notification ID"})
+	}
+
+	found, err := h.notifications.MarkNotificationRead(c.UserContext(), uint(userID), uint(notificationID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notification"})
+	}
+
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Notification marked as read",
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/metrics"
+	"ezwait/internal/oidc"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OIDCHandler serves social sign-in
+type OIDCHandler struct {
+	logins
+	social   *services.OIDCService
+	validate *validation.Validator
+}
+
+// To build an OIDCHandler on the services it uses
+func NewOIDCHandler(s *services.Services, validate *validation.Validator) *OIDCHandler {
+	return &OIDCHandler{logins: newLogins(s), social: s.OIDC, validate: validate}
+}
+
+func (h *OIDCHandler) StartOIDCHandler(c *fiber.Ctx) error {
+	authURL, err := h.social.StartOIDC(c.UserContext(), c.Params("provider"), c.Query("role"))
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		return c.Status(404).JSON(fiber.Map{"error": "Unknown sign-in provider"})
+	}
+
+	if errors.Is(err, services.ErrInvalidRole) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		logger.Error(c.UserContext(), "Failed to start social sign-in", "provider", c.Params("provider"), "error", err)
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to reach the sign-in provider"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":           "Open the authorization URL to continue",
+		"authorization_url": authURL,
+	})
+}
+
+// To handle the provider redirect (GET, or POST for Apple's form_post), as
+// well as a mobile app forwarding the code and state it captured
+func (h *OIDCHandler) OIDCCallbackHandler(c *fiber.Ctx) error {
+	var input struct {
+		Code  string `json:"code" form:"code" query:"code"`
+		State string `json:"state" form:"state" query:"state"`
+		Error string `json:"error" form:"error" query:"error"`
+	}
+
+	if c.Method() == fiber.MethodGet {
+		_ = c.QueryParser(&input)
+	} else {
+		_ = c.BodyParser(&input)
+	}
+
+	if input.Error != "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Sign-in was cancelled or denied: " + input.Error})
+	}
+
+	if input.Code == "" || input.State == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Code and state are required"})
+	}
+
+	result, err := h.social.FinishOIDC(c.UserContext(), c.Params("provider"), input.State, input.Code)
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		return c.Status(404).JSON(fiber.Map{"error": "Unknown sign-in provider"})
+	}
+
+	if errors.Is(err, services.ErrInvalidOAuthState) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrEmailNotVerified) || errors.Is(err, services.ErrEmailRequired) {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		logger.Error(c.UserContext(), "Failed to finish social sign-in", "provider", c.Params("provider"), "error", err)
+		metrics.Login("oidc", false)
+		return c.Status(401).JSON(fiber.Map{"error": "Sign-in could not be verified"})
+	}
+
+	if result.User == nil {
+		return c.Status(200).JSON(fiber.Map{
+			"message":         "Choose a role to finish creating your account",
+			"signup_required": true,
+			"signup_token":    result.SignupToken,
+		})
+	}
+
+	metrics.Login("oidc", true)
+	return h.completeLogin(c, result.User)
+}
+
+func (h *OIDCHandler) CompleteOIDCSignupHandler(c *fiber.Ctx) error {
+	var input struct {
+		SignupToken string `json:"signup_token" validate:"required"`
+		Role        string `json:"role" validate:"required,oneof=stylist customer"`
+		Number      string `json:"number" validate:"omitempty,phone"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	user, err := h.social.CompleteOIDCSignup(c.UserContext(), input.SignupToken, input.Role, input.Number)
+	if errors.Is(err, services.ErrInvalidRole) || errors.Is(err, services.ErrInvalidOAuthState) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrEmailNotVerified) || errors.Is(err, services.ErrEmailRequired) {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create account"})
+	}
+
+	return h.completeLogin(c, user)
+}
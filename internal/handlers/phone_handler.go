@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/metrics"
+	"ezwait/internal/services"
+	"ezwait/internal/utils"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PhoneHandler serves phone login and linking a number to an account
+type PhoneHandler struct {
+	logins
+	users    *services.UserService
+	phones   *services.PhoneService
+	validate *validation.Validator
+}
+
+// To build a PhoneHandler on the services it uses
+func NewPhoneHandler(s *services.Services, validate *validation.Validator) *PhoneHandler {
+	return &PhoneHandler{logins: newLogins(s), users: s.Users, phones: s.Phones, validate: validate}
+}
+
+func (h *PhoneHandler) RequestPhoneLoginHandler(c *fiber.Ctx) error {
+	var input struct {
+		Number string `json:"number" validate:"required,phone"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err := h.phones.RequestPhoneLogin(c.UserContext(), input.Number, c.IP())
+	if errors.Is(err, utils.ErrInvalidPhone) {
+		return validation.Respond(c, validation.FieldError("number", validation.FieldInvalidPhone))
+	}
+
+	var resendErr *services.ResendError
+	if errors.As(err, &resendErr) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(resendErr.RetryAfter.Seconds())+1))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many codes requested, please try again later"})
+	}
+
+	if err != nil {
+		logger.Error(c.UserContext(), "Failed to send phone login code", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send login code"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "If this number belongs to an account, a login code has been sent",
+	})
+}
+
+func (h *PhoneHandler) VerifyPhoneLoginHandler(c *fiber.Ctx) error {
+	var input struct {
+		Number string `json:"number" validate:"required,phone"`
+		Code   string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	user, err := h.phones.VerifyPhoneLogin(c.UserContext(), input.Number, input.Code)
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTooManyAttempts) {
+		metrics.Login("phone", false)
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+
+	metrics.Login("phone", true)
+
+	return h.completeLogin(c, user)
+}
+
+// To text a code to a number the signed-in user wants to log in with
+func (h *PhoneHandler) RequestPhoneVerificationHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	var input struct {
+		Number string `json:"number" validate:"required,phone"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err = h.phones.RequestPhoneVerification(c.UserContext(), user, input.Number, c.IP())
+	if errors.Is(err, utils.ErrInvalidPhone) {
+		return validation.Respond(c, validation.FieldError("number", validation.FieldInvalidPhone))
+	}
+
+	if errors.Is(err, services.ErrPhoneTaken) {
+		return validation.Respond(c, validation.FieldError("number", validation.FieldTaken))
+	}
+
+	var resendErr *services.ResendError
+	if errors.As(err, &resendErr) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(resendErr.RetryAfter.Seconds())+1))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many codes requested, please try again later"})
+	}
+
+	if err != nil {
+		logger.Error(c.UserContext(), "Failed to send phone verification code", "error", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send verification code"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "A verification code has been sent to your number",
+	})
+}
+
+// To link the number to the signed-in user, after which it works for phone login
+func (h *PhoneHandler) ConfirmPhoneHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	var input struct {
+		Number string `json:"number" validate:"required,phone"`
+		Code   string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err = h.phones.ConfirmPhone(c.UserContext(), user, input.Number, input.Code)
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTooManyAttempts) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrPhoneTaken) {
+		return validation.Respond(c, validation.FieldError("number", validation.FieldTaken))
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify number"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Phone number verified, you can now log in with it",
+		"data": fiber.Map{
+			"number":            user.Number,
+			"phone_verified_at": user.PhoneVerifiedAt,
+		},
+	})
+}
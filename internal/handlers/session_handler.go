@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// To describe the device a login comes from. Apps can name themselves with
+// the X-Device-Name header, e.g. "Ada's iPhone".
+func sessionDevice(c *fiber.Ctx) services.SessionDevice {
+	return services.SessionDevice{
+		Name:      c.Get("X-Device-Name"),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+}
+
+// SessionHandler serves the endpoints for a user's own sessions
+type SessionHandler struct {
+	sessions *services.SessionService
+}
+
+// To build a SessionHandler on the session service
+func NewSessionHandler(sessions *services.SessionService) *SessionHandler {
+	return &SessionHandler{sessions: sessions}
+}
+
+func (h *SessionHandler) ListSessionsHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+	currentSession, _ := c.Locals("session_id").(string)
+
+	sessions, err := h.sessions.ListSessions(c.UserContext(), uint(userID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sessions"})
+	}
+
+	response := []fiber.Map{}
+	for _, s := range sessions {
+		response = append(response, fiber.Map{
+			"id":           s.ID,
+			"device_name":  s.DeviceName,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"current":      s.ID == currentSession,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Sessions retrieved successfully",
+		"data":    response,
+	})
+}
+
+func (h *SessionHandler) RevokeSessionHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	err := h.sessions.RevokeSession(c.UserContext(), uint(userID), c.Params("sessionId"))
+	if errors.Is(err, services.ErrSessionNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to end session"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Session ended successfully",
+	})
+}
+
+// To log out everywhere, including the current device
+func (h *SessionHandler) RevokeAllSessionsHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	if err := h.sessions.RevokeUserTokens(c.UserContext(), uint(userID)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to end sessions"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Logged out of all sessions",
+	})
+}
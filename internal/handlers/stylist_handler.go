@@ -2,16 +2,28 @@ package handlers
 
 import (
 	"encoding/json"
-	"ezwait/config"
+	"errors"
 	"ezwait/internal/models"
-	"fmt"
+	"ezwait/internal/repository"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
 	"strconv"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func CreateStylistProfile(c *fiber.Ctx) error {
+// StylistHandler serves the stylist profile endpoints
+type StylistHandler struct {
+	stylists *services.StylistService
+	validate *validation.Validator
+}
+
+// To build a StylistHandler on the stylist service
+func NewStylistHandler(stylists *services.StylistService, validate *validation.Validator) *StylistHandler {
+	return &StylistHandler{stylists: stylists, validate: validate}
+}
+
+func (h *StylistHandler) CreateStylistProfile(c *fiber.Ctx) error {
 
 	stylistIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
@@ -19,21 +31,16 @@ func CreateStylistProfile(c *fiber.Ctx) error {
 	}
 	stylistID := uint(stylistIDFloat)
 
-	var existingStylist models.Stylist
-	if err := config.DB.Where("stylist_id = ?", stylistID).First(&existingStylist).Error; err == nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Stylist profile already exists"})
-	}
-
 	// To extract data from the JSON req
 	var input struct {
 		StylistID          uint                     `json:"stylist_id"`
-		ProfilePicture     string                   `json:"profile_picture"`
-		Services           []models.Service         `json:"services"`
-		SampleOfServices   []models.SampleOfService `json:"sample_of_services"`
-		AvailableTimeSlots []string                 `json:"available_time_slots"`
+		ProfilePicture     string                   `json:"profile_picture" validate:"omitempty,url"`
+		Services           []models.Service         `json:"services" validate:"max=50,dive"`
+		SampleOfServices   []models.SampleOfService `json:"sample_of_services" validate:"max=50,dive"`
+		AvailableTimeSlots []string                 `json:"available_time_slots" validate:"max=200,dive,required,max=50"`
 	}
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid input: " + err.Error()})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
 	// To Convert slices to JSON
@@ -53,51 +60,45 @@ func CreateStylistProfile(c *fiber.Ctx) error {
 	}
 
 	// To create Stylist Profile
-	stylist := models.Stylist{
-		StylistID:            stylistID,
-		ActiveStatus:         true,
-		ProfilePicture:       input.ProfilePicture,
-		Ratings:              0.0,
-		Services:             servicesJSON,
-		SampleOfServices:     sampleServicesJSON,
-		AvailableTimeSlots:   timeSlotsJSON,
-		NoOfCustomerBookings: 0,
-		NoOfCurrentCustomers: 0,
-		CreatedAt:            time.Now(),
-	}
-
-	// To save to database
-	if err := config.DB.Create(&stylist).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create stylist profile: " + err.Error()})
+	stylist, err := h.stylists.CreateProfile(c.UserContext(), stylistID, models.Stylist{
+		ProfilePicture:     input.ProfilePicture,
+		Services:           servicesJSON,
+		SampleOfServices:   sampleServicesJSON,
+		AvailableTimeSlots: timeSlotsJSON,
+	})
+	if errors.Is(err, services.ErrStylistProfileExists) {
+		return c.Status(400).JSON(fiber.Map{"error": "Stylist profile already exists"})
 	}
-
-	// To fetch the stylist details and the Stylist user data
-	if err := config.DB.Preload("User").Where("stylist_id = ?", stylistID).First(&stylist).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stylist profile: " + err.Error()})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create stylist profile"})
 	}
 
 	// Success Response
 	return c.Status(201).JSON(fiber.Map{
 		"message": "Stylist profile created successfully",
-		"data":    stylist,
+		"data":    stylistResponse(stylist),
 	})
 }
 
-func ViewStylistProfile(c *fiber.Ctx) error {
-	stylistID := c.Params("stylistId")
-
-	var stylist models.Stylist
-	if err := config.DB.Where("stylist_id = ?", stylistID).First(&stylist).Error; err != nil {
+func (h *StylistHandler) ViewStylistProfile(c *fiber.Ctx) error {
+	stylistID, err := strconv.Atoi(c.Params("stylistId"))
+	if err != nil || stylistID < 1 {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Stylist not found",
 		})
 	}
 
-	// To fetch the associated user details of the stylist
-	var user models.User
-	if err := config.DB.Where("id = ?", stylist.StylistID).First(&user).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stylist profile: " + err.Error()})
+	// To fetch the stylist with their user details
+	stylist, err := h.stylists.Get(c.UserContext(), uint(stylistID))
+	if errors.Is(err, services.ErrStylistNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Stylist not found",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stylist profile"})
 	}
+	user := stylist.User
 
 	// To convert JSONB (Byte array) in PostgreSQL DB to Go structs
 	var services []models.Service
@@ -148,7 +149,7 @@ func ViewStylistProfile(c *fiber.Ctx) error {
 }
 
 // PARTIAL UPDATE
-func UpdateStylistProfile(c *fiber.Ctx) error {
+func (h *StylistHandler) UpdateStylistProfile(c *fiber.Ctx) error {
 	stylistIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(500).JSON(fiber.Map{"error": "Invalid user ID format"})
@@ -156,75 +157,68 @@ func UpdateStylistProfile(c *fiber.Ctx) error {
 	stylistID := uint(stylistIDFloat)
 
 	var input struct {
-		ProfilePicture       *string                   `json:"profile_picture"`
-		Services             *[]models.Service         `json:"services"`
-		SampleOfServices     *[]models.SampleOfService `json:"sample_of_services"`
-		AvailableTimeSlots   *[]string                 `json:"available_time_slots"`
+		ProfilePicture       *string                   `json:"profile_picture" validate:"omitempty,url"`
+		Services             *[]models.Service         `json:"services" validate:"omitempty,max=50,dive"`
+		SampleOfServices     *[]models.SampleOfService `json:"sample_of_services" validate:"omitempty,max=50,dive"`
+		AvailableTimeSlots   *[]string                 `json:"available_time_slots" validate:"omitempty,max=200,dive,required,max=50"`
 		ActiveStatus         *bool                     `json:"active_status"`
-		NoOfCurrentCustomers *int                      `json:"no_of_current_customers"`
+		NoOfCurrentCustomers *int                      `json:"no_of_current_customers" validate:"omitempty,gte=0"`
 	}
 
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	// To know if stylist exist
-	var stylist models.Stylist
-
-	if err := config.DB.Where("stylist_id = ?", stylistID).First(&stylist).Error; err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Stylist not found" + err.Error(),
-		})
-	}
-
-	// To convert JSONB to JSON
-	if input.Services != nil {
-		servicesJSON, _ := json.Marshal(input.Services)
-		stylist.Services = servicesJSON
-	}
+	// To update only the provided fields
+	stylist, err := h.stylists.Update(c.UserContext(), stylistID, func(stylist *models.Stylist) {
+		// To convert JSONB to JSON
+		if input.Services != nil {
+			servicesJSON, _ := json.Marshal(input.Services)
+			stylist.Services = servicesJSON
+		}
 
-	if input.SampleOfServices != nil {
-		sampleServicesJSON, _ := json.Marshal(input.SampleOfServices)
-		stylist.SampleOfServices = sampleServicesJSON
-	}
+		if input.SampleOfServices != nil {
+			sampleServicesJSON, _ := json.Marshal(input.SampleOfServices)
+			stylist.SampleOfServices = sampleServicesJSON
+		}
 
-	if input.AvailableTimeSlots != nil {
-		timeSlotsJSON, _ := json.Marshal(input.AvailableTimeSlots)
-		stylist.AvailableTimeSlots = timeSlotsJSON
-	}
+		if input.AvailableTimeSlots != nil {
+			timeSlotsJSON, _ := json.Marshal(input.AvailableTimeSlots)
+			stylist.AvailableTimeSlots = timeSlotsJSON
+		}
 
-	// To update only the provided fields
-	if input.ProfilePicture != nil {
-		stylist.ProfilePicture = *input.ProfilePicture
-	}
+		if input.ProfilePicture != nil {
+			stylist.ProfilePicture = *input.ProfilePicture
+		}
 
-	if input.ActiveStatus != nil {
-		stylist.ActiveStatus = *input.ActiveStatus
-	}
+		if input.ActiveStatus != nil {
+			stylist.ActiveStatus = *input.ActiveStatus
+		}
 
-	if input.NoOfCurrentCustomers != nil {
-		stylist.NoOfCurrentCustomers = *input.NoOfCurrentCustomers
+		if input.NoOfCurrentCustomers != nil {
+			stylist.NoOfCurrentCustomers = *input.NoOfCurrentCustomers
+		}
+	})
+	if errors.Is(err, services.ErrStylistNotFound) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Stylist not found",
+		})
 	}
-
-	// To save the updated Profile to DB
-	if err := config.DB.Save(&stylist).Error; err != nil {
-
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to update profile" + err.Error(),
+			"error": "Failed to update profile",
 		})
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"message": "Profile updated successfully",
-		"data":    stylist,
+		"data":    stylistResponse(stylist),
 	})
 
 }
 
 // FULL UPDATE
-func EditStylistProfile(c *fiber.Ctx) error {
+func (h *StylistHandler) EditStylistProfile(c *fiber.Ctx) error {
 	stylistIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(500).JSON(fiber.Map{
@@ -234,26 +228,16 @@ func EditStylistProfile(c *fiber.Ctx) error {
 	stylistID := uint(stylistIDFloat)
 	// To parse the request body
 	var input struct {
-		ProfilePicture       string                   `json:"profile_picture"`
-		Services             []models.Service         `json:"services"`
-		SampleOfServices     []models.SampleOfService `json:"sample_of_services"`
-		AvailableTimeSlots   []string                 `json:"available_time_slots"`
+		ProfilePicture       string                   `json:"profile_picture" validate:"omitempty,url"`
+		Services             []models.Service         `json:"services" validate:"max=50,dive"`
+		SampleOfServices     []models.SampleOfService `json:"sample_of_services" validate:"max=50,dive"`
+		AvailableTimeSlots   []string                 `json:"available_time_slots" validate:"max=200,dive,required,max=50"`
 		ActiveStatus         bool                     `json:"active_status"`
-		NoOfCurrentCustomers int                      `json:"no_of_current_customers"`
-	}
-
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
+		NoOfCurrentCustomers int                      `json:"no_of_current_customers" validate:"gte=0"`
 	}
 
-	// To find stylist in database
-	var stylist models.Stylist
-	if err := config.DB.Where("stylist_id = ?", stylistID).First(&stylist).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Stylist not found",
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
 	// To convert JSONB fields to JSON
@@ -262,15 +246,20 @@ func EditStylistProfile(c *fiber.Ctx) error {
 	sampleServicesJSON, _ := json.Marshal(input.SampleOfServices)
 
 	// To overwrite all fields
-	stylist.ProfilePicture = input.ProfilePicture
-	stylist.Services = servicesJSON
-	stylist.SampleOfServices = sampleServicesJSON
-	stylist.AvailableTimeSlots = timeSlotsJSON
-	stylist.ActiveStatus = input.ActiveStatus
-	stylist.NoOfCurrentCustomers = input.NoOfCurrentCustomers
-
-	// To save the updated profile
-	if err := config.DB.Save(&stylist).Error; err != nil {
+	stylist, err := h.stylists.Update(c.UserContext(), stylistID, func(stylist *models.Stylist) {
+		stylist.ProfilePicture = input.ProfilePicture
+		stylist.Services = servicesJSON
+		stylist.SampleOfServices = sampleServicesJSON
+		stylist.AvailableTimeSlots = timeSlotsJSON
+		stylist.ActiveStatus = input.ActiveStatus
+		stylist.NoOfCurrentCustomers = input.NoOfCurrentCustomers
+	})
+	if errors.Is(err, services.ErrStylistNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Stylist not found",
+		})
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to edit profile",
 		})
@@ -278,93 +267,77 @@ func EditStylistProfile(c *fiber.Ctx) error {
 
 	return c.Status(200).JSON(fiber.Map{
 		"message": "Profile edited successfully",
-		"data":    stylist,
+		"data":    stylistResponse(stylist),
 	})
 }
 
-func ViewAllStylists(c *fiber.Ctx) error {
+func (h *StylistHandler) ViewAllStylists(c *fiber.Ctx) error {
 	// To extract optional query params
-	serviceFilter := c.Query("service")
-	sortBy := c.Query("sort")
+	filter := repository.StylistFilter{
+		Service: c.Query("service"),
+		Sort:    c.Query("sort"),
+	}
 	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("page", "10"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
 
 	// For valid pagination values
 	if page < 1 {
 		page = 1
 	}
-	if limit < 1 || limit < 50 {
+	if limit < 1 || limit > 50 {
 		limit = 10
 	}
 
-	// To query the stylist table
-	query := config.DB.Model(&models.Stylist{})
-
-	if serviceFilter != "" {
-		query = query.Where("services @> ?", fmt.Sprintf(`"%s"`, serviceFilter))
-	}
-
-	if sortBy == "ratings" {
-		query = query.Order("ratings DESC")
-	} else if sortBy == "name" {
-		query = query.Order("name ASC")
-	}
-
-	offset := (page - 1) * limit
-	query = query.Offset(offset).Limit(limit)
-
-	// To fetch the queries from DB
-	var stylists []models.Stylist
-	if err := query.Find(&stylists).Error; err != nil {
+	// To fetch the stylists with their user details, leaving out those whose account is being deleted
+	stylists, err := h.stylists.List(c.UserContext(), filter, repository.Page{Limit: limit, Offset: (page - 1) * limit})
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to fetch stylist: " + err.Error(),
+			"error": "Failed to fetch stylist",
 		})
 	}
 
 	// To prepare response with additional user details
-	var stylistResponse []map[string]interface{}
-
-	for _, stylist := range stylists {
-		var user models.User
-
-		// To fetch the stylists name & location
-		if err := config.DB.Where("id = ?", stylist.StylistID).First(&user).Error; err != nil {
-			fmt.Println("Error fetching user details: ", err)
-			continue
-		}
-
-		// To convert JSONB response to Go struct
-		var services []models.Service
-		var sampleImgs []models.SampleOfService
-		var timeSlots []string
+	var response []fiber.Map
 
-		_ = json.Unmarshal(stylist.Services, &services)
-		_ = json.Unmarshal(stylist.SampleOfServices, &sampleImgs)
-		_ = json.Unmarshal(stylist.AvailableTimeSlots, &timeSlots)
-
-		// To append stylist info to response array
-		stylistResponse = append(stylistResponse, map[string]interface{}{
-			"id":                      stylist.ID,
-			"stylist_id":              stylist.StylistID,
-			"name":                    user.Name,
-			"location":                user.Location,
-			"active_status":           stylist.ActiveStatus,
-			"profile_picture":         stylist.ProfilePicture,
-			"ratings":                 stylist.Ratings,
-			"services":                services,
-			"sample_of_services":      sampleImgs,
-			"available_time_slots":    timeSlots,
-			"no_of_customer_bookings": stylist.NoOfCustomerBookings,
-			"auto_confirm":            stylist.AutoConfirm,
-			"created_at":              stylist.CreatedAt,
-		})
+	for i := range stylists {
+		profile := stylistResponse(&stylists[i])
+		profile["name"] = stylists[i].User.Name
+		profile["location"] = stylists[i].User.Location
+		response = append(response, profile)
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"message": "Stylists retrieved successfully",
-		"data":    stylistResponse,
+		"data":    response,
 		"page":    page,
 		"limit":   limit,
 	})
 
 }
+
+// To describe a stylist profile, with its JSONB fields decoded, never the
+// model itself, whose User holds the password hash
+func stylistResponse(stylist *models.Stylist) fiber.Map {
+	var services []models.Service
+	var sampleImgs []models.SampleOfService
+	var timeSlots []string
+
+	_ = json.Unmarshal(stylist.Services, &services)
+	_ = json.Unmarshal(stylist.SampleOfServices, &sampleImgs)
+	_ = json.Unmarshal(stylist.AvailableTimeSlots, &timeSlots)
+
+	return fiber.Map{
+		"id":                      stylist.ID,
+		"stylist_id":              stylist.StylistID,
+		"active_status":           stylist.ActiveStatus,
+		"profile_picture":         stylist.ProfilePicture,
+		"ratings":                 stylist.Ratings,
+		"services":                services,
+		"sample_of_services":      sampleImgs,
+		"available_time_slots":    timeSlots,
+		"no_of_customer_bookings": stylist.NoOfCustomerBookings,
+		"no_of_current_customers": stylist.NoOfCurrentCustomers,
+		"auto_confirm":            stylist.AutoConfirm,
+		"created_at":              stylist.CreatedAt,
+	}
+}
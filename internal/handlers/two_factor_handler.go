@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"ezwait/internal/metrics"
+	"ezwait/internal/models"
+	"ezwait/internal/services"
+	"ezwait/internal/utils"
+	"ezwait/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TwoFactorHandler serves the 2FA login step and the authenticator settings
+type TwoFactorHandler struct {
+	logins
+	users     *services.UserService
+	passwords *services.PasswordService
+	validate  *validation.Validator
+}
+
+// To build a TwoFactorHandler on the services it uses
+func NewTwoFactorHandler(s *services.Services, validate *validation.Validator) *TwoFactorHandler {
+	return &TwoFactorHandler{logins: newLogins(s), users: s.Users, passwords: s.Passwords, validate: validate}
+}
+
+func (h *TwoFactorHandler) TwoFactorLoginHandler(c *fiber.Ctx) error {
+	var input struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+		RecoveryCode   string `json:"recovery_code" validate:"max=32"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	userID, err := h.keys.VerifyChallengeToken(input.ChallengeToken, utils.TokenTypeTwoFactorLogin)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired challenge"})
+	}
+
+	user, err := h.users.Get(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired challenge"})
+	}
+
+	err = h.twoFactor.VerifyTwoFactor(c.UserContext(), user, input.Code, input.RecoveryCode)
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) || errors.Is(err, services.ErrTwoFactorNotEnabled) {
+		metrics.Login("2fa", false)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+
+	metrics.Login("2fa", true)
+
+	return h.issueSession(c, user, fiber.Map{"message": "Login successful"})
+}
+
+func (h *TwoFactorHandler) SetupTwoFactorHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	secret, uri, err := h.twoFactor.BeginTwoFactorSetup(c.UserContext(), user)
+	if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor setup"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Scan the QR code with your authenticator app, then confirm with a code",
+		"data": fiber.Map{
+			"secret":      secret,
+			"otpauth_uri": uri,
+		},
+	})
+}
+
+func (h *TwoFactorHandler) ConfirmTwoFactorHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	var input struct {
+		Code string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	// To stop before 2FA is turned on when the enrollment can't end in a
+	// session, so the recovery codes aren't shown only to be lost
+	enrolling, _ := c.Locals("two_factor_enrollment").(bool)
+	if enrolling && user.DeletionRequestedAt != nil {
+		return h.deletionScheduled(c, user)
+	}
+
+	recoveryCodes, err := h.twoFactor.ConfirmTwoFactor(c.UserContext(), user, input.Code)
+	if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) || errors.Is(err, services.ErrTwoFactorNotStarted) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor authentication"})
+	}
+
+	// To finish the login of a user who was forced to enroll, through
+	// issueSession so an account scheduled for deletion gets no session
+	if enrolling {
+		return h.issueSession(c, user, fiber.Map{
+			"message":        "Two-factor authentication enabled, store your recovery codes safely",
+			"recovery_codes": recoveryCodes,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled, store your recovery codes safely",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func (h *TwoFactorHandler) DisableTwoFactorHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	var input struct {
+		Password string `json:"password" validate:"required,max=256"`
+		Code     string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	match, err := h.passwords.Verify(user.Password, input.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check password"})
+	}
+	if !match {
+		return c.Status(401).JSON(fiber.Map{"error": "Password is incorrect"})
+	}
+
+	err = h.twoFactor.DisableTwoFactor(c.UserContext(), user, input.Code)
+	if errors.Is(err, services.ErrTwoFactorNotEnabled) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrTwoFactorRequired) {
+		return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor authentication"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodesHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized user"})
+	}
+
+	var input struct {
+		Code string `json:"code" validate:"required,numeric,len=6"`
+	}
+
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
+	}
+
+	err = h.twoFactor.VerifyTwoFactor(c.UserContext(), user, input.Code, "")
+	if errors.Is(err, services.ErrTwoFactorNotEnabled) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(c.UserContext(), user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate recovery codes"})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":        "New recovery codes generated, the old ones no longer work",
+		"recovery_codes": codes,
+	})
+}
+
+// To load the authenticated user through users
+func currentUser(c *fiber.Ctx, users *services.UserService) (*models.User, error) {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return nil, errors.New("unauthorized")
+	}
+
+	return users.Get(c.UserContext(), uint(userID))
+}
@@ -1,13 +1,25 @@
 package handlers
 
 import (
-	"ezwait/config"
-	"ezwait/internal/models"
+	"errors"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func UpdateUserProfile(c *fiber.Ctx) error {
+// UserHandler serves the endpoints for a user's own profile
+type UserHandler struct {
+	users    *services.UserService
+	validate *validation.Validator
+}
+
+// To build a UserHandler on the user service
+func NewUserHandler(users *services.UserService, validate *validation.Validator) *UserHandler {
+	return &UserHandler{users: users, validate: validate}
+}
+
+func (h *UserHandler) UpdateUserProfile(c *fiber.Ctx) error {
 	userIDFloat, ok := c.Locals("user").(float64)
 	if !ok {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID format"})
@@ -15,33 +27,34 @@ func UpdateUserProfile(c *fiber.Ctx) error {
 	userID := uint(userIDFloat)
 
 	var input struct {
-		Name           string `json:"name"`
-		Email          string `json:"email"`
-		Number         string `json:"number"`
-		Location       string `json:"location"`
-		ProfilePicture string `json:"profile_picture"`
+		Name           string `json:"name" validate:"required,max=255"`
+		Email          string `json:"email" validate:"required,email,max=255"`
+		Number         string `json:"number" validate:"omitempty,phone"`
+		Location       string `json:"location" validate:"max=255"`
+		ProfilePicture string `json:"profile_picture" validate:"omitempty,url"`
 	}
 
-	if err := c.BodyParser(&input); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid input",
-		})
+	if err := h.validate.ParseBody(c, &input); err != nil {
+		return validation.Respond(c, err)
 	}
 
-	var user models.User
-	if err := config.DB.First(&user, userID).Error; err != nil {
+	// To keep emails unique and make a new email prove itself again
+	user, err := h.users.UpdateProfile(c.UserContext(), userID, services.ProfileUpdate{
+		Name:           input.Name,
+		Email:          input.Email,
+		Number:         input.Number,
+		Location:       input.Location,
+		ProfilePicture: input.ProfilePicture,
+	})
+	if errors.Is(err, services.ErrUserNotFound) {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "User not found",
 		})
 	}
-
-	user.Name = input.Name
-	user.Email = input.Email
-	user.Number = input.Number
-	user.Location = input.Location
-	user.ProfilePicture = input.ProfilePicture
-
-	if err := config.DB.Save(&user).Error; err != nil {
+	if errors.Is(err, services.ErrEmailTaken) {
+		return validation.Respond(c, validation.FieldError("email", validation.FieldTaken))
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to update user profile",
 		})
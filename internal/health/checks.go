@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/jobs"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// MigrationStatus is reported by the migrations check
+type MigrationStatus struct {
+	Current  uint `json:"current"`
+	Expected uint `json:"expected"`
+	Dirty    bool `json:"dirty"`
+}
+
+// To check that Postgres answers a ping
+func Database(db *gorm.DB) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return nil, err
+		}
+
+		stats := sqlDB.Stats()
+		return map[string]int{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+		}, nil
+	}
+}
+
+// To check that the database is at the expected migration and not left
+// dirty by a failed one
+func Migrations(db *gorm.DB, expected uint) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		var row struct {
+			Version uint
+			Dirty   bool
+		}
+		if err := db.WithContext(ctx).Raw("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&row).Error; err != nil {
+			return nil, err
+		}
+
+		status := MigrationStatus{Current: row.Version, Expected: expected, Dirty: row.Dirty}
+		if status.Dirty {
+			return status, fmt.Errorf("migration %d is dirty", status.Current)
+		}
+		if status.Current < status.Expected {
+			return status, fmt.Errorf("database is at migration %d, expected %d", status.Current, status.Expected)
+		}
+
+		return status, nil
+	}
+}
+
+// To check that the background job loops are running
+func Scheduler(s *jobs.Scheduler) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		status := s.Status()
+		if !s.Alive() {
+			return status, errors.New("background jobs are not running")
+		}
+		return status, nil
+	}
+}
@@ -0,0 +1,114 @@
+// Package health runs the readiness checks behind GET /readyz. Checks are
+// registered on a Registry at startup and each reports its own status and
+// details.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckTimeout bounds each check so a hung dependency can't hang the probe
+const CheckTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable. Details, when not nil, are
+// included in the response, also for passing checks.
+type Check func(ctx context.Context) (details interface{}, err error)
+
+// Result is the outcome of one check
+type Result struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the readiness checks of one server
+type Registry struct {
+	mu       sync.Mutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// To create a registry without checks
+func New() *Registry {
+	return &Registry{}
+}
+
+// To fail readiness from now on, called when shutdown starts so traffic
+// moves to other instances before this one stops
+func (r *Registry) Drain() {
+	r.draining.Store(true)
+}
+
+// To add a readiness check, replacing any with the same name
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i].check = check
+			return
+		}
+	}
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// To run every check concurrently, ready is true when all of them pass
+func (r *Registry) Run(ctx context.Context) (results map[string]Result, ready bool) {
+	r.mu.Lock()
+	current := append([]namedCheck(nil), r.checks...)
+	r.mu.Unlock()
+
+	results = make(map[string]Result, len(current)+1)
+	ready = true
+
+	if r.draining.Load() {
+		results["shutdown"] = Result{Status: StatusFail, Error: "server is shutting down"}
+		ready = false
+	}
+
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+	)
+	for _, c := range current {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+			defer cancel()
+
+			details, err := c.check(checkCtx)
+			result := Result{Status: StatusOK, Details: details}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			results[c.name] = result
+			if err != nil {
+				ready = false
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return results, ready
+}
@@ -0,0 +1,101 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"ezwait/internal/models"
+	"ezwait/internal/services"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	start(t)
+
+	registration := map[string]string{
+		"name":             "Ada",
+		"email":            "ada@example.com",
+		"role":             "customer",
+		"password":         testPassword,
+		"confirm_password": testPassword,
+	}
+
+	r := request(t, "POST", "/api/v1/user/register", "", registration)
+	expectStatus(t, r, 201)
+	if r.Get("data", "email") != "ada@example.com" || r.Get("data", "email_verified") != false {
+		t.Fatalf("unexpected registration: %v", r.Body)
+	}
+
+	// The email is taken now
+	r = request(t, "POST", "/api/v1/user/register", "", registration)
+	expectStatus(t, r, 400)
+	if r.Get("error", "fields", "email") != "taken" {
+		t.Fatalf("want email taken, got %v", r.Body)
+	}
+
+	r = request(t, "POST", "/api/v1/user/login", "", map[string]string{
+		"email":    "ada@example.com",
+		"password": "not-the-password",
+	})
+	expectStatus(t, r, 401)
+
+	r = request(t, "POST", "/api/v1/user/login", "", map[string]string{
+		"email":    "ada@example.com",
+		"password": testPassword,
+	})
+	expectStatus(t, r, 200)
+	token, _ := r.Get("token").(string)
+	if token == "" || r.Get("data", "role") != "customer" {
+		t.Fatalf("unexpected login: %v", r.Body)
+	}
+
+	// The access token works on protected routes
+	expectStatus(t, request(t, "GET", "/api/v1/view-all/bookings", token, nil), 200)
+	expectStatus(t, request(t, "GET", "/api/v1/view-all/bookings", "", nil), 401)
+}
+
+func TestParallelLoginGuessesAreCounted(t *testing.T) {
+	start(t)
+
+	user := seedUser(t, models.RoleCustomer, "Ada")
+	body, err := json.Marshal(map[string]string{"email": user.Email, "password": "not-the-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A burst of wrong guesses sent together, each one must be counted
+	// before the next is let through
+	const guesses = 20
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+
+	// The free attempts and the one that starts the delay get checked, the
+	// rest are turned away
+	checked := services.AccountThrottlePolicy.FreeAttempts + 1
+	if counts[401] != checked || counts[429] != guesses-checked {
+		t.Fatalf("got %v, want %d checked (401) and %d refused (429)", counts, checked, guesses-checked)
+	}
+}
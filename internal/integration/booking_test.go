@@ -0,0 +1,185 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"ezwait/internal/models"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMakeBookingRejectsOverlap(t *testing.T) {
+	start(t)
+
+	stylist := seedStylist(t, "Grace")
+	stylistToken := login(t, stylist)
+	first := login(t, seedUser(t, models.RoleCustomer, "Alan"))
+	second := login(t, seedUser(t, models.RoleCustomer, "Ada"))
+
+	id := book(t, first, bookingBody(stylist.ID, 10, 0, time.Hour))
+
+	// Overlapping the 10:00-11:00 booking, from either side or inside it
+	for _, body := range []map[string]interface{}{
+		bookingBody(stylist.ID, 10, 30, time.Hour),
+		bookingBody(stylist.ID, 9, 30, time.Hour),
+		bookingBody(stylist.ID, 10, 15, 30*time.Minute),
+	} {
+		r := request(t, "POST", "/api/v1/customer/bookings", second, body)
+		expectStatus(t, r, 400)
+		if r.Get("error") != "Time slot already booked" {
+			t.Fatalf("unexpected error: %v", r.Body)
+		}
+	}
+
+	// Back to back is fine
+	book(t, second, bookingBody(stylist.ID, 11, 0, time.Hour))
+
+	// Unknown stylists can't be booked
+	expectStatus(t, request(t, "POST", "/api/v1/customer/bookings", second, bookingBody(stylist.ID+100, 10, 0, time.Hour)), 404)
+
+	// A cancelled booking frees its slot
+	r := request(t, "PATCH", fmt.Sprintf("/api/v1/bookings/%d/status", id), stylistToken, map[string]string{"new_status": "cancelled"})
+	expectStatus(t, r, 200)
+	book(t, second, bookingBody(stylist.ID, 10, 30, 30*time.Minute))
+}
+
+func TestConcurrentBookingsOfOneSlot(t *testing.T) {
+	start(t)
+
+	stylist := seedStylist(t, "Grace")
+	var tokens []string
+	for i := 0; i < 8; i++ {
+		tokens = append(tokens, login(t, seedUser(t, models.RoleCustomer, fmt.Sprintf("Customer%d", i))))
+	}
+
+	// To book the same slot from every customer at once
+	body, err := json.Marshal(bookingBody(stylist.ID, 10, 0, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]int, len(tokens))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/api/v1/customer/bookings", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, status := range statuses {
+		switch status {
+		case 201:
+			booked++
+		case 400:
+		default:
+			t.Fatalf("unexpected statuses %v", statuses)
+		}
+	}
+	if booked != 1 {
+		t.Fatalf("%d customers booked the slot, want 1: %v", booked, statuses)
+	}
+}
+
+func TestEditBookingOwnership(t *testing.T) {
+	start(t)
+
+	stylist := seedStylist(t, "Grace")
+	stylistToken := login(t, stylist)
+	owner := login(t, seedUser(t, models.RoleCustomer, "Alan"))
+	other := login(t, seedUser(t, models.RoleCustomer, "Ada"))
+
+	id := book(t, owner, bookingBody(stylist.ID, 10, 0, time.Hour))
+	path := fmt.Sprintf("/api/v1/customer/edit/bookings/%d", id)
+
+	// Someone else's booking
+	expectStatus(t, request(t, "PUT", path, other, bookingBody(stylist.ID, 14, 0, time.Hour)), 403)
+
+	// Stylists don't edit bookings, they change their status
+	expectStatus(t, request(t, "PUT", path, stylistToken, bookingBody(stylist.ID, 14, 0, time.Hour)), 403)
+
+	// Moving within its own slot doesn't clash with itself
+	r := request(t, "PUT", path, owner, bookingBody(stylist.ID, 10, 30, time.Hour))
+	expectStatus(t, r, 200)
+	want := bookingBody(stylist.ID, 10, 30, time.Hour)["start_time"].(string)
+	if got, _ := r.Get("data", "start_time").(string); !sameInstant(got, want) {
+		t.Fatalf("start_time %s, want %s", got, want)
+	}
+
+	// Only pending bookings can be edited
+	expectStatus(t, request(t, "PATCH", fmt.Sprintf("/api/v1/bookings/%d/status", id), stylistToken, map[string]string{"new_status": "confirmed"}), 200)
+	expectStatus(t, request(t, "PUT", path, owner, bookingBody(stylist.ID, 14, 0, time.Hour)), 400)
+}
+
+func TestUpdateBookingStatusTransitions(t *testing.T) {
+	start(t)
+
+	stylist := seedStylist(t, "Grace")
+	stylistToken := login(t, stylist)
+	otherStylistToken := login(t, seedStylist(t, "Katherine"))
+	adminToken := login(t, seedUser(t, models.RoleAdmin, "Margaret"))
+	customer := login(t, seedUser(t, models.RoleCustomer, "Alan"))
+
+	id := book(t, customer, bookingBody(stylist.ID, 10, 0, time.Hour))
+	path := fmt.Sprintf("/api/v1/bookings/%d/status", id)
+
+	// Another stylist's booking
+	expectStatus(t, request(t, "PATCH", path, otherStylistToken, map[string]string{"new_status": "confirmed"}), 403)
+
+	// Customers can't change the status
+	expectStatus(t, request(t, "PATCH", path, customer, map[string]string{"new_status": "confirmed"}), 403)
+
+	steps := []struct {
+		status string
+		want   int
+	}{
+		{"completed", 400}, // a pending booking must be confirmed first
+		{"pending", 400},
+		{"confirmed", 200},
+		{"pending", 400},
+		{"completed", 200},
+		{"cancelled", 400}, // completed is final
+	}
+	for _, step := range steps {
+		r := request(t, "PATCH", path, stylistToken, map[string]string{"new_status": step.status})
+		if r.Status != step.want {
+			t.Fatalf("to %s: status %d, want %d: %v", step.status, r.Status, step.want, r.Body)
+		}
+		if step.want == 200 && r.Get("data", "booking_status") != step.status {
+			t.Fatalf("to %s: unexpected booking: %v", step.status, r.Body)
+		}
+		// The stylist sees the customer's contact details, never their password hash
+		if step.want == 200 && (r.Get("data", "user", "name") != "Alan" || r.Get("data", "user", "password") != nil) {
+			t.Fatalf("to %s: unexpected customer details: %v", step.status, r.Body)
+		}
+	}
+
+	// Staff may manage any booking
+	id = book(t, customer, bookingBody(stylist.ID, 14, 0, time.Hour))
+	r := request(t, "PATCH", fmt.Sprintf("/api/v1/bookings/%d/status", id), adminToken, map[string]string{"new_status": "cancelled"})
+	expectStatus(t, r, 200)
+
+	// Unknown statuses are validation errors
+	expectStatus(t, request(t, "PATCH", path, stylistToken, map[string]string{"new_status": "done"}), 400)
+}
+
+// To compare two RFC 3339 times whatever their zone
+func sameInstant(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	return errA == nil && errB == nil && ta.Equal(tb)
+}
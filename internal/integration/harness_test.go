@@ -0,0 +1,294 @@
+// Package integration drives the API over HTTP against a real Postgres with
+// the migrations applied. The tests skip when no Postgres is available, see
+// testenv.StartPostgres.
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/health"
+	"ezwait/internal/jobs"
+	"ezwait/internal/mailer"
+	"ezwait/internal/middleware"
+	"ezwait/internal/migrations"
+	"ezwait/internal/models"
+	"ezwait/internal/oidc"
+	"ezwait/internal/passwords"
+	"ezwait/internal/routers"
+	"ezwait/internal/services"
+	"ezwait/internal/sms"
+	"ezwait/internal/testenv"
+	"ezwait/internal/utils"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Password of every seeded user
+const testPassword = "Sturdy-Harbor-Lantern-42"
+
+var (
+	app         *fiber.App
+	db          *gorm.DB
+	hasher      *passwords.Hasher
+	providers   *oidc.Providers
+	databaseURL string
+	skipReason  string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	logger.Init("text", "error")
+
+	pg, err := testenv.StartPostgres()
+	// To fail rather than skip where the suite must run, such as CI
+	if errors.Is(err, testenv.ErrNoPostgres) && os.Getenv("TEST_REQUIRE_POSTGRES") == "" {
+		skipReason = err.Error()
+		return m.Run()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration: starting Postgres:", err)
+		return 1
+	}
+	defer pg.Stop()
+
+	databaseURL = pg.DSN()
+	if err := setup(databaseURL); err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// To migrate the database and build the app the way cmd/server does
+func setup(dsn string) error {
+	cfg := config.Default()
+	cfg.Database.URL = dsn
+	cfg.JWT.Secret = "integration-tests-only-secret-0123456789"
+	cfg.Passwords.CheckBreached = false
+	cfg.Passwords.BcryptCost = 4
+
+	var err error
+	db, err = config.ConnectDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrations.New(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && !errors.Is(err, migrations.ErrNoChange) {
+		return fmt.Errorf("applying migrations: %w", err)
+	}
+
+	keys, err := utils.LoadKeyRing(cfg.JWT)
+	if err != nil {
+		return err
+	}
+	providers = oidc.NewProviders()
+
+	var policy passwords.Policy
+	policy, hasher, err = passwords.New(cfg.Passwords)
+	if err != nil {
+		return err
+	}
+
+	svc := services.New(db, services.Deps{
+		Accounts:  cfg.Accounts,
+		Exports:   cfg.Exports,
+		JWT:       cfg.JWT,
+		Keys:      keys,
+		Providers: providers,
+		Policy:    policy,
+		Hasher:    hasher,
+		Mailer:    &mailer.LogMailer{},
+		SMS:       &sms.ConsoleSender{},
+	})
+
+	app = fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	routers.SetupRoutes(app, &cfg, routers.NewHandlers(svc, validation.New(cfg.Accounts.DefaultCountryCode), jobs.NewTasks(), health.New()))
+
+	return nil
+}
+
+// To skip without a database, otherwise start the test from empty tables
+func start(t *testing.T) {
+	t.Helper()
+	if skipReason != "" {
+		t.Skip(skipReason)
+	}
+
+	var tables []string
+	err := db.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).Scan(&tables).Error
+	if err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+	if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("emptying tables: %v", err)
+	}
+}
+
+// response is a decoded API response
+type response struct {
+	Status int
+	Body   map[string]interface{}
+}
+
+// To read a nested field of the body, e.g. r.Get("data", "user", "email")
+func (r response) Get(path ...string) interface{} {
+	var value interface{} = r.Body
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// To call the API, as the holder of token when it isn't empty
+func request(t *testing.T, method, path, token string, body interface{}) response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	r := response{Status: resp.StatusCode}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, path, err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &r.Body); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, raw, err)
+		}
+	}
+
+	return r
+}
+
+// To fail unless r has status, showing the body
+func expectStatus(t *testing.T, r response, status int) {
+	t.Helper()
+	if r.Status != status {
+		t.Fatalf("status %d, want %d: %v", r.Status, status, r.Body)
+	}
+}
+
+// To add a user with testPassword
+func seedUser(t *testing.T, role, name string) models.User {
+	t.Helper()
+
+	hash, err := hasher.Hash(testPassword)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+
+	user := models.User{
+		Name:     name,
+		Email:    strings.ToLower(name) + "@example.com",
+		Role:     role,
+		Password: hash,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("seeding user: %v", err)
+	}
+	return user
+}
+
+// To add a stylist user with a profile offering Braids
+func seedStylist(t *testing.T, name string) models.User {
+	t.Helper()
+
+	user := seedUser(t, models.RoleStylist, name)
+	stylist := models.Stylist{
+		StylistID:          user.ID,
+		ActiveStatus:       true,
+		Services:           json.RawMessage(`[{"name": "Braids", "price": 50}]`),
+		SampleOfServices:   json.RawMessage(`[]`),
+		AvailableTimeSlots: json.RawMessage(`["09:00-17:00"]`),
+	}
+	if err := db.Omit("User").Create(&stylist).Error; err != nil {
+		t.Fatalf("seeding stylist: %v", err)
+	}
+	return user
+}
+
+// To sign user in through the API and return the access token
+func login(t *testing.T, user models.User) string {
+	t.Helper()
+
+	r := request(t, "POST", "/api/v1/user/login", "", map[string]string{
+		"email":    user.Email,
+		"password": testPassword,
+	})
+	expectStatus(t, r, 200)
+
+	token, _ := r.Get("token").(string)
+	if token == "" {
+		t.Fatalf("login returned no token: %v", r.Body)
+	}
+	return token
+}
+
+// To describe a booking of stylist on the day after tomorrow (UTC), from
+// hour for the given duration
+func bookingBody(stylistID uint, hour, minute int, duration time.Duration) map[string]interface{} {
+	day := time.Now().UTC().AddDate(0, 0, 2)
+	start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.UTC)
+
+	return map[string]interface{}{
+		"stylist_id":  stylistID,
+		"start_time":  start.Format(time.RFC3339),
+		"end_time":    start.Add(duration).Format(time.RFC3339),
+		"booking_day": start.Format("2006-01-02"),
+	}
+}
+
+// To book through the API and return the booking ID
+func book(t *testing.T, token string, body map[string]interface{}) uint {
+	t.Helper()
+
+	r := request(t, "POST", "/api/v1/customer/bookings", token, body)
+	expectStatus(t, r, 201)
+
+	id, _ := r.Get("data", "id").(float64)
+	return uint(id)
+}
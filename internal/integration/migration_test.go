@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"errors"
+	"ezwait/internal/migrations"
+	"ezwait/internal/models"
+	"testing"
+)
+
+// To check 000016 upgrades a database holding duplicate emails, some only
+// differing in case, and bookings of users without a stylist profile instead
+// of failing half way
+func TestReconcileMigrationFixesExistingData(t *testing.T) {
+	start(t)
+
+	m, err := migrations.New(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// To leave the schema at the newest version for the other tests
+	defer func() {
+		if err := m.Up(); err != nil && !errors.Is(err, migrations.ErrNoChange) {
+			t.Fatalf("migrating back up: %v", err)
+		}
+	}()
+
+	if err := m.Migrate(15); err != nil {
+		t.Fatalf("migrating down to 15: %v", err)
+	}
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if err := db.Exec(query, args...).Error; err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec(`INSERT INTO users (id, name, email, number, role, password) VALUES
+		(1, 'Grace', 'grace@example.com', '', 'stylist', 'x'),
+		(2, 'Grace Again', 'Grace@Example.com', '', 'customer', 'x'),
+		(3, 'Alan', 'ALAN@example.com', '', 'customer', 'x')`)
+	// Grace has bookings but, like accounts from before stylist profiles, no profile
+	exec(`INSERT INTO bookings (user_id, stylist_id, start_time, end_time, booking_day, booking_status) VALUES
+		(3, 1, '2030-01-02 10:00', '2030-01-02 11:00', '2030-01-02', 'confirmed')`)
+
+	if err := m.Migrate(16); err != nil {
+		t.Fatalf("migrating up to 16: %v", err)
+	}
+
+	var emails []string
+	if err := db.Raw("SELECT email FROM users ORDER BY id").Scan(&emails).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grace@example.com", "grace@example.com.duplicate-2", "alan@example.com"}
+	if len(emails) != len(want) {
+		t.Fatalf("emails %v, want %v", emails, want)
+	}
+	for i := range want {
+		if emails[i] != want[i] {
+			t.Fatalf("emails %v, want %v", emails, want)
+		}
+	}
+
+	// The index holds whatever the case
+	if err := db.Exec(`INSERT INTO users (name, email, number, role, password) VALUES ('Alan', 'Alan@example.com', '', 'customer', 'x')`).Error; err == nil {
+		t.Fatal("inserted an email differing only in case")
+	}
+
+	var active []bool
+	if err := db.Raw("SELECT active_status FROM stylists WHERE stylist_id = 1").Scan(&active).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0] {
+		t.Fatalf("want one inactive profile backfilled for the stylist, got %v", active)
+	}
+
+	var bookings int64
+	if err := db.Raw("SELECT COUNT(*) FROM bookings").Scan(&bookings).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bookings != 1 {
+		t.Fatalf("%d bookings left, want the one booking kept", bookings)
+	}
+}
+
+// To check 000012 rolls back with admins in the table and comes back up
+func TestAdminRoleMigrationRoundTrip(t *testing.T) {
+	start(t)
+
+	m, err := migrations.New(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	defer func() {
+		if err := m.Up(); err != nil && !errors.Is(err, migrations.ErrNoChange) {
+			t.Fatalf("migrating back up: %v", err)
+		}
+	}()
+
+	admin := seedUser(t, models.RoleAdmin, "Margaret")
+
+	if err := m.Migrate(11); err != nil {
+		t.Fatalf("migrating down to 11: %v", err)
+	}
+
+	var role string
+	if err := db.Raw("SELECT role FROM users WHERE id = ?", admin.ID).Scan(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	if role != models.RoleCustomer {
+		t.Fatalf("admin has role %q after the rollback, want %q", role, models.RoleCustomer)
+	}
+
+	if err := m.Migrate(12); err != nil {
+		t.Fatalf("migrating up to 12: %v", err)
+	}
+	if err := db.Exec("UPDATE users SET role = 'admin' WHERE id = ?", admin.ID).Error; err != nil {
+		t.Fatalf("promoting after migrating back up: %v", err)
+	}
+}
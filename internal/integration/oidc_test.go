@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"ezwait/internal/models"
+	"ezwait/internal/oidc/oidctest"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDCLinksOnlyVerifiedEmails(t *testing.T) {
+	start(t)
+
+	idp := oidctest.New(t, "ezwait")
+	providers.Register(idp.Provider("fake", "http://localhost/api/v1/auth/fake/callback"))
+	ada := seedUser(t, models.RoleCustomer, "Ada")
+
+	// The account never verified its email, whoever holds the IdP account can't take it over
+	idp.Claims = jwt.MapClaims{"sub": "fake-ada", "email": ada.Email, "email_verified": true}
+	expectStatus(t, signInWith(t, idp, "fake"), 409)
+
+	// Both sides must vouch for the email
+	if err := db.Model(&ada).Update("email_verified", true).Error; err != nil {
+		t.Fatalf("verifying email: %v", err)
+	}
+	idp.Claims["email_verified"] = false
+	expectStatus(t, signInWith(t, idp, "fake"), 409)
+
+	idp.Claims["email_verified"] = true
+	r := signInWith(t, idp, "fake")
+	expectStatus(t, r, 200)
+	if r.Get("data", "email") != ada.Email {
+		t.Fatalf("signed in as %v, want %s", r.Get("data", "email"), ada.Email)
+	}
+
+	// The identity is linked now, so it signs in even once the IdP stops sharing the email
+	idp.Claims = jwt.MapClaims{"sub": "fake-ada"}
+	expectStatus(t, signInWith(t, idp, "fake"), 200)
+}
+
+// To sign in through provider, approving the sign-in at idp, and return the callback response
+func signInWith(t *testing.T, idp *oidctest.IdP, provider string) response {
+	t.Helper()
+
+	r := request(t, "GET", "/api/v1/auth/"+provider+"/start", "", nil)
+	expectStatus(t, r, 200)
+	authURL, _ := r.Get("authorization_url").(string)
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization URL: %v", err)
+	}
+
+	callback := url.Values{}
+	callback.Set("code", idp.Authorize(t, authURL))
+	callback.Set("state", u.Query().Get("state"))
+	return request(t, "GET", "/api/v1/auth/"+provider+"/callback?"+callback.Encode(), "", nil)
+}
@@ -0,0 +1,21 @@
+package integration
+
+import (
+	"context"
+	"ezwait/internal/models"
+	"ezwait/internal/schemacheck"
+	"testing"
+)
+
+// To fail when the migrations and the models disagree, as cmd/schema-check does
+func TestSchemaMatchesModels(t *testing.T) {
+	start(t)
+
+	drifts, err := schemacheck.Check(context.Background(), db, models.All()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, drift := range drifts {
+		t.Error(drift)
+	}
+}
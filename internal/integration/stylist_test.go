@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"ezwait/internal/models"
+	"fmt"
+	"testing"
+)
+
+func TestCreateStylistProfile(t *testing.T) {
+	start(t)
+
+	stylist := seedUser(t, models.RoleStylist, "Grace")
+	stylistToken := login(t, stylist)
+	customerToken := login(t, seedUser(t, models.RoleCustomer, "Alan"))
+
+	profile := map[string]interface{}{
+		"services":             []map[string]interface{}{{"name": "Braids", "price": 50}},
+		"available_time_slots": []string{"09:00-12:00"},
+	}
+
+	// Customers can't have a stylist profile
+	expectStatus(t, request(t, "POST", "/api/v1/stylists/profile", customerToken, profile), 403)
+
+	r := request(t, "POST", "/api/v1/stylists/profile", stylistToken, profile)
+	expectStatus(t, r, 201)
+	if r.Get("data", "stylist_id") != float64(stylist.ID) || r.Get("data", "active_status") != true {
+		t.Fatalf("unexpected profile: %v", r.Body)
+	}
+	// The profile alone, the stylist's account stays out of it
+	if r.Get("data", "user") != nil {
+		t.Fatalf("profile came with the account: %v", r.Body)
+	}
+
+	// One profile per stylist
+	expectStatus(t, request(t, "POST", "/api/v1/stylists/profile", stylistToken, profile), 400)
+
+	r = request(t, "GET", fmt.Sprintf("/api/v1/stylists/%d/profile", stylist.ID), customerToken, nil)
+	expectStatus(t, r, 200)
+	services, _ := r.Get("data", "services").([]interface{})
+	if len(services) != 1 || r.Get("user", "name") != "Grace" {
+		t.Fatalf("unexpected profile: %v", r.Body)
+	}
+
+	// Listing filters on the service name
+	r = request(t, "GET", "/api/v1/customer/view/all-stylists/?service=Braids", customerToken, nil)
+	expectStatus(t, r, 200)
+	if listed, _ := r.Get("data").([]interface{}); len(listed) != 1 {
+		t.Fatalf("want the stylist listed for Braids, got %v", r.Body)
+	}
+
+	r = request(t, "GET", "/api/v1/customer/view/all-stylists/?service=Nails", customerToken, nil)
+	expectStatus(t, r, 200)
+	if listed, _ := r.Get("data").([]interface{}); len(listed) != 0 {
+		t.Fatalf("want no stylist listed for Nails, got %v", r.Body)
+	}
+}
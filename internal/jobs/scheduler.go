@@ -0,0 +1,158 @@
+package jobs
+
+import (
+	"context"
+	"ezwait/internal/metrics"
+	"ezwait/internal/tracing"
+	"ezwait/pkg/logger"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Job is a background task run on a fixed interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs in the background until its context is cancelled
+type Scheduler struct {
+	jobs []Job
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	started bool
+	status  map[string]*JobStatus
+}
+
+// JobStatus is the latest state of a job, reported by the readiness check
+type JobStatus struct {
+	Name      string     `json:"name"`
+	Running   bool       `json:"running"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// To create a scheduler for the given jobs
+func New(jobs ...Job) *Scheduler {
+	status := make(map[string]*JobStatus, len(jobs))
+	for _, job := range jobs {
+		status[job.Name] = &JobStatus{Name: job.Name}
+	}
+
+	return &Scheduler{jobs: jobs, status: status}
+}
+
+// To start every job, each runs once right away and then on its interval
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		s.setRunning(job.Name, true)
+		go func(job Job) {
+			defer s.wg.Done()
+			defer s.setRunning(job.Name, false)
+
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				err := run(ctx, job)
+				s.recordRun(job.Name, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+}
+
+// To wait for running jobs to return after the context is cancelled
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// To tell whether the scheduler was started and every job loop is still going
+func (s *Scheduler) Alive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return false
+	}
+	for _, status := range s.status {
+		if !status.Running {
+			return false
+		}
+	}
+
+	return true
+}
+
+// To get a snapshot of every job's status, in the order jobs were given
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		result = append(result, *s.status[job.Name])
+	}
+
+	return result
+}
+
+func (s *Scheduler) setRunning(name string, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status[name].Running = running
+}
+
+func (s *Scheduler) recordRun(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.status[name].LastRun = &now
+	s.status[name].LastError = ""
+	if err != nil {
+		s.status[name].LastError = err.Error()
+	}
+}
+
+// To run a job once, a failing or panicking job must not stop the others
+func run(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			metrics.JobRun(job.Name, 0, err)
+			logger.Error(ctx, "Job panicked", "job", job.Name, "panic", r)
+		}
+	}()
+
+	// To trace the run, so the job's queries are grouped under it
+	ctx, span := tracing.Tracer().Start(ctx, "job "+job.Name)
+	defer span.End()
+
+	start := time.Now()
+	err = job.Run(ctx)
+	metrics.JobRun(job.Name, time.Since(start), err)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error(ctx, "Job failed", "job", job.Name, "error", err)
+	}
+
+	return err
+}
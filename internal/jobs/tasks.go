@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+)
+
+// Tasks runs one-off background work started by requests, such as emails
+// sent after the response, so shutdown can wait for it to finish
+type Tasks struct {
+	wg sync.WaitGroup
+}
+
+// To create an empty task group
+func NewTasks() *Tasks {
+	return &Tasks{}
+}
+
+// To run fn in the background, traced, logged and recovered like a job run.
+// fn gets ctx without its cancellation, the request it came from has usually
+// ended by then, but its values such as the request ID are kept
+func (t *Tasks) Go(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		run(ctx, Job{Name: name, Run: fn})
+	}()
+}
+
+// To wait for every started task to return, call once no more can be started
+func (t *Tasks) Wait() {
+	t.wg.Wait()
+}
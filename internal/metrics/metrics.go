@@ -0,0 +1,150 @@
+// Package metrics defines the Prometheus metrics exposed at /metrics: HTTP
+// request rates and latencies per route, database pool stats and domain
+// counters for bookings, logins and background jobs.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ezwait"
+
+// Login results
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	bookingsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bookings_created_total",
+		Help:      "Bookings created, by their initial status.",
+	}, []string{"status"})
+
+	bookingTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "booking_status_transitions_total",
+		Help:      "Booking status changes, by previous and new status.",
+	}, []string{"from", "to"})
+
+	bookingConfirmations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "booking_confirmations_total",
+		Help:      "Confirmed bookings, by whether the stylist's auto-confirm did it or someone confirmed by hand.",
+	}, []string{"mode"})
+
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by method (password, phone, 2fa, oidc) and result.",
+	}, []string{"method", "result"})
+
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Background job runs by job and result.",
+	}, []string{"job", "result"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job run time.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300},
+	}, []string{"job"})
+)
+
+// To export the stats of the database connection pool
+func RegisterDBStats(db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// To record the rate, status and latency of each request by its route
+// pattern (e.g. /api/v1/view/bookings/:bookingId), so IDs don't become labels
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// To count errors the app's error handler hasn't written yet
+		status = fiber.StatusInternalServerError
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		}
+	}
+
+	// To group requests no route matched under one label
+	route := c.Route().Path
+	if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+		route = "unmatched"
+	}
+
+	httpRequests.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// To count a new booking; auto-confirmed bookings also count as confirmations
+func BookingCreated(status string) {
+	bookingsCreated.WithLabelValues(status).Inc()
+	if status == "confirmed" {
+		bookingConfirmations.WithLabelValues("auto").Inc()
+	}
+}
+
+// To count a booking status change; confirmations here were made by hand
+func BookingStatusChanged(from, to string) {
+	if from == to {
+		return
+	}
+
+	bookingTransitions.WithLabelValues(from, to).Inc()
+	if to == "confirmed" {
+		bookingConfirmations.WithLabelValues("manual").Inc()
+	}
+}
+
+// To count a login attempt for a method: password, phone, 2fa or oidc
+func Login(method string, success bool) {
+	result := LoginFailure
+	if success {
+		result = LoginSuccess
+	}
+
+	logins.WithLabelValues(method, result).Inc()
+}
+
+// To record a background job run
+func JobRun(job string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	jobRuns.WithLabelValues(job, result).Inc()
+	jobDuration.WithLabelValues(job).Observe(duration.Seconds())
+}
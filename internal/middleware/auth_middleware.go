@@ -1,15 +1,32 @@
 package middleware
 
 import (
-	"ezwait/internal/models"
+	"crypto/subtle"
+	"ezwait/internal/rbac"
+	"ezwait/internal/services"
 	"ezwait/internal/utils"
+	"ezwait/pkg/logger"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// Guards are the middleware that check requests against the services
+type Guards struct {
+	sessions *services.SessionService
+	throttle *services.ThrottleService
+	users    *services.UserService
+	phones   *services.PhoneService
+	keys     *utils.KeyRing
+}
+
+// To build the guards on the services
+func NewGuards(s *services.Services) *Guards {
+	return &Guards{sessions: s.Sessions, throttle: s.Throttle, users: s.Users, phones: s.Phones, keys: s.Keys}
+}
+
 // To check if the user is authenticated
-func AuthMiddleware(c *fiber.Ctx) error {
+func (g *Guards) AuthMiddleware(c *fiber.Ctx) error {
 	// Get token from Authorization header
 	token := c.Get("Authorization")
 	// To check if the token is provided
@@ -23,7 +40,7 @@ func AuthMiddleware(c *fiber.Ctx) error {
 	token = strings.Replace(token, "Bearer ", "", -1)
 
 	// To validate JWT
-	claims, err := utils.VerifyToken(token)
+	claims, err := g.keys.VerifyToken(token)
 
 	if err != nil {
 		return c.Status(401).JSON(fiber.Map{
@@ -31,6 +48,13 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		})
 	}
 
+	// To refuse challenge tokens and anything else that isn't an access token
+	if typ, _ := (*claims)["typ"].(string); typ != utils.TokenTypeAccess {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
 	// To extract user and role from claims
 	user, ok := (*claims)["user"].(float64)
 
@@ -49,54 +73,132 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		})
 	}
 
+	jti, ok := (*claims)["jti"].(string)
+
+	if !ok || jti == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	// To reject tokens ended by logout, password change or account deletion
+	revoked, err := g.sessions.IsTokenRevoked(c.UserContext(), jti)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to validate token",
+		})
+	}
+
+	if revoked {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Token has been revoked",
+		})
+	}
+
+	// To reject tokens whose session was signed out from another device
+	sessionID, _ := (*claims)["sid"].(string)
+	if sessionID == "" {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	active, err := g.sessions.TouchSession(c.UserContext(), sessionID, c.IP())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to validate token",
+		})
+	}
+
+	if !active {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Session has ended",
+		})
+	}
+
 	// To add claims to locals for use in handlers
 	c.Locals("user", user)
 
 	c.Locals("role", role)
 
+	c.Locals("jti", jti)
+
+	c.Locals("session_id", sessionID)
+
+	// To tag the request's log lines with who made it
+	c.SetUserContext(logger.WithAttrs(c.UserContext(), logger.UserIDKey, uint(user), logger.RoleKey, role))
+
 	return c.Next()
 }
 
-func ValidateUser(c *fiber.Ctx) error {
-	var user models.User
+// To authenticate with either an access token or a 2FA enrollment challenge,
+// so users forced into 2FA can finish setup before getting a full session
+func (g *Guards) AllowTwoFactorEnrollment(c *fiber.Ctx) error {
+	token := strings.Replace(c.Get("Authorization"), "Bearer ", "", -1)
 
-	if err := c.BodyParser(&user); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	if userID, err := g.keys.VerifyChallengeToken(token, utils.TokenTypeTwoFactorEnroll); err == nil {
+		c.Locals("user", float64(userID))
+		c.Locals("two_factor_enrollment", true)
+		return c.Next()
 	}
 
-	// To check for any missing fields
-	if user.Name == "" || user.Email == "" || user.Password == "" || user.ConfirmPassword == "" || user.Role == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "All fields are required"})
-	}
+	return g.AuthMiddleware(c)
+}
 
-	// To validate email format
-	if !strings.Contains(user.Email, "@") {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid email format"})
-	}
+// To ensure the user's role grants every one of the given permissions
+func RequirePermission(perms ...rbac.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
 
-	return c.Next()
+		for _, perm := range perms {
+			if !rbac.Can(role, perm) {
+				return c.Status(403).JSON(fiber.Map{
+					"error": "You do not have permission to perform this action",
+				})
+			}
+		}
+
+		return c.Next()
+	}
 }
 
-// To ensure the user is a customer
-func ValidateCustomer(c *fiber.Ctx) error {
-	role := c.Locals("role")
+// To guard the metrics endpoint with a static bearer token, open when token is empty
+func MetricsAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return c.Next()
+		}
+
+		given := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		return c.Next()
+	}
+}
 
-	if role != "customer" {
-		return c.Status(403).JSON(fiber.Map{
-			"error": "Only customers are permitted",
+// To ensure the authenticated user has verified their email
+func (g *Guards) RequireVerifiedEmail(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Unauthorized user",
 		})
 	}
 
-	return c.Next()
-}
-
-// To ensure the user is a customer
-func ValidateStylist(c *fiber.Ctx) error {
-	role := c.Locals("role")
+	user, err := g.users.Get(c.UserContext(), uint(userID))
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Unauthorized user",
+		})
+	}
 
-	if role != "stylist" {
+	if !user.EmailVerified {
 		return c.Status(403).JSON(fiber.Map{
-			"error": "Only stylists are permitted",
+			"error": "Please verify your email to continue",
 		})
 	}
 
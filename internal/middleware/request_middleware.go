@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"errors"
+	"ezwait/internal/apperror"
+	"ezwait/internal/validation"
+	"ezwait/pkg/logger"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"gorm.io/gorm"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+// Incoming request IDs are kept only when they look like IDs, so clients
+// can't inject arbitrary text into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// To give each request an ID, reusing the caller's X-Request-ID when valid,
+// and echo it in the response and every log line of the request
+func RequestID(c *fiber.Ctx) error {
+	id := c.Get(HeaderRequestID)
+	if !validRequestID.MatchString(id) {
+		id = utils.UUIDv4()
+	}
+
+	c.Locals("request_id", id)
+	c.Set(HeaderRequestID, id)
+	c.SetUserContext(logger.WithAttrs(c.UserContext(), logger.RequestIDKey, id))
+
+	return c.Next()
+}
+
+// To get the ID given to the request by RequestID
+func RequestIDFrom(c *fiber.Ctx) string {
+	id, _ := c.Locals("request_id").(string)
+	return id
+}
+
+// To log every request with its route, status and latency
+func AccessLog(c *fiber.Ctx) error {
+	start := time.Now()
+
+	// To let the error handler write the response first, so the logged status is the one sent
+	if err := c.Next(); err != nil {
+		if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	status := c.Response().StatusCode()
+	args := []any{
+		"method", c.Method(),
+		"path", c.Path(),
+		"route", c.Route().Path,
+		"status", status,
+		"latency", time.Since(start),
+		"ip", c.IP(),
+		"bytes", len(c.Response().Body()),
+	}
+
+	switch {
+	case status >= 500:
+		logger.Error(c.UserContext(), "Request", args...)
+	case status >= 400:
+		logger.Warn(c.UserContext(), "Request", args...)
+	default:
+		logger.Info(c.UserContext(), "Request", args...)
+	}
+
+	return nil
+}
+
+// To turn a panic in a handler into a JSON 500 carrying the request ID
+func Recover(c *fiber.Ctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(c.UserContext(), "Panic while handling request",
+				"panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+
+			err = c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":      "Internal server error",
+				"request_id": RequestIDFrom(c),
+			})
+		}
+	}()
+
+	return c.Next()
+}
+
+// To map errors returned by handlers to responses, used as the app's
+// fiber.Config.ErrorHandler
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	if appErr, ok := apperror.As(err); ok {
+		if appErr.Status >= 500 {
+			logger.Error(c.UserContext(), appErr.Message, "error", appErr.Err)
+			return c.Status(appErr.Status).JSON(fiber.Map{
+				"error":      appErr.Message,
+				"request_id": RequestIDFrom(c),
+			})
+		}
+		return c.Status(appErr.Status).JSON(fiber.Map{"error": appErr.Message})
+	}
+
+	var validationErr *validation.Error
+	if errors.As(err, &validationErr) {
+		return validation.Respond(c, validationErr)
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Not found"})
+	}
+
+	logger.Error(c.UserContext(), "Unhandled error", "error", err)
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":      "Internal server error",
+		"request_id": RequestIDFrom(c),
+	})
+}
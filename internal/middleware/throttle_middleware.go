@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"ezwait/internal/utils"
+	"ezwait/pkg/logger"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// To guard a credential-checking endpoint against brute force. Requests from a
+// locked account or IP get a 429. Otherwise the attempt is reserved, counted
+// as failed, before the handler runs so parallel guesses can't slip past the
+// check, and settled by the response status: 401 keeps it as a failure, 2xx
+// makes it a success and anything else gives it back.
+func (g *Guards) BruteForceGuard(scope string, identify func(c *fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identifier := identify(c)
+		ip := c.IP()
+
+		wait, err := g.throttle.ReserveAttempt(c.UserContext(), scope, identifier, ip)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to check login attempts",
+			})
+		}
+
+		if wait > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+			return c.Status(429).JSON(fiber.Map{
+				"error":       "Too many failed attempts, please try again later",
+				"retry_after": int(wait.Seconds()) + 1,
+			})
+		}
+
+		if err := c.Next(); err != nil {
+			if err := g.throttle.ReleaseAttempt(c.UserContext(), scope, identifier, ip); err != nil {
+				logger.Error(c.UserContext(), "Failed to release login attempt", "error", err)
+			}
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status != fiber.StatusUnauthorized && (status < 200 || status >= 300) {
+			err = g.throttle.ReleaseAttempt(c.UserContext(), scope, identifier, ip)
+		} else {
+			err = g.throttle.CompleteAttempt(c.UserContext(), scope, identifier, ip, status != fiber.StatusUnauthorized)
+		}
+		if err != nil {
+			logger.Error(c.UserContext(), "Failed to record login attempt", "error", err)
+		}
+
+		return nil
+	}
+}
+
+// To identify the account by the "email" field of the request body
+func EmailFromBody(c *fiber.Ctx) string {
+	var body struct {
+		Email string `json:"email"`
+	}
+	_ = c.BodyParser(&body)
+
+	return body.Email
+}
+
+// To identify the account by the authenticated user
+func UserFromToken(c *fiber.Ctx) string {
+	userID, ok := c.Locals("user").(float64)
+	if !ok {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// To identify the account by the 2FA login challenge in the request body
+func (g *Guards) UserFromChallenge(c *fiber.Ctx) string {
+	var body struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	_ = c.BodyParser(&body)
+
+	userID, err := g.keys.VerifyChallengeToken(body.ChallengeToken, utils.TokenTypeTwoFactorLogin)
+	if err != nil {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// To identify the account by the normalized "number" field of the request body
+func (g *Guards) NumberFromBody(c *fiber.Ctx) string {
+	var body struct {
+		Number string `json:"number"`
+	}
+	_ = c.BodyParser(&body)
+
+	number, err := g.phones.NormalizePhone(body.Number)
+	if err != nil {
+		return body.Number
+	}
+
+	return number
+}
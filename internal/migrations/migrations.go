@@ -0,0 +1,174 @@
+// Package migrations applies the SQL migrations embedded from db/migrations
+// with golang-migrate, for the migrate command and --migrate-on-start.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"ezwait/db"
+	"ezwait/pkg/logger"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Where migration files live in the repo, used by Create
+const Dir = "db/migrations"
+
+// Key of the advisory lock held while migrating on start. It differs from
+// golang-migrate's own lock, which is taken inside it.
+const startLockKey = 727_000_001
+
+var (
+	ErrNoVersion = migrate.ErrNilVersion
+	ErrNoChange  = migrate.ErrNoChange
+	namePattern  = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// To open a migrator for dsn. It uses its own connection, Close releases it.
+func New(dsn string) (*migrate.Migrate, error) {
+	source, err := iofs.New(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := pgx.WithInstance(conn, &pgx.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "pgx5", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	m.Log = logAdapter{}
+
+	return m, nil
+}
+
+// To apply every pending migration, waiting for any other instance doing the
+// same so only one of them runs them
+func UpWithLock(ctx context.Context, dsn string) error {
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// To hold the session lock on one connection for the whole run
+	lockConn, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer lockConn.Close()
+
+	if _, err := lockConn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", startLockKey); err != nil {
+		return fmt.Errorf("waiting for the migration lock: %w", err)
+	}
+	defer lockConn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", startLockKey)
+
+	m, err := New(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, ErrNoChange) {
+		return err
+	}
+
+	return nil
+}
+
+// To find the newest embedded migration, the version the schema should be at
+func Latest() (uint, error) {
+	entries, err := fs.ReadDir(db.Migrations, "migrations")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		if version, ok := parseVersion(entry.Name()); ok && version > latest {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
+
+// To write an empty up/down pair numbered after the newest file in dir and
+// return their paths
+func Create(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("migration name %q may only use letters, digits and underscores", name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		if version, ok := parseVersion(entry.Name()); ok && version > latest {
+			latest = version
+		}
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%06d_%s", latest+1, name))
+	paths := []string{base + ".up.sql", base + ".down.sql"}
+	for _, path := range paths {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	return paths, nil
+}
+
+// To read the version of a file named like 000012_name.up.sql
+func parseVersion(name string) (uint, bool) {
+	if !strings.HasSuffix(name, ".up.sql") {
+		return 0, false
+	}
+
+	prefix, _, _ := strings.Cut(name, "_")
+	version, err := strconv.ParseUint(prefix, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(version), true
+}
+
+// logAdapter sends golang-migrate's progress to the app logger
+type logAdapter struct{}
+
+func (logAdapter) Printf(format string, v ...interface{}) {
+	logger.Info(context.Background(), strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (logAdapter) Verbose() bool {
+	return false
+}
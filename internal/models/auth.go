@@ -1 +1,65 @@
 package models
+
+import "time"
+
+// RefreshToken is one link in a rotating refresh token family. Only the
+// SHA-256 hash of the token is stored, never the token itself.
+type RefreshToken struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index;not null" json:"user_id"`
+	FamilyID        string     `gorm:"index;not null" json:"family_id"`
+	TokenHash       string     `gorm:"uniqueIndex;not null" json:"-"`
+	AccessJTI       string     `gorm:"column:access_jti;not null" json:"-"`
+	AccessExpiresAt time.Time  `json:"-"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	UsedAt          *time.Time `json:"used_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// RevokedToken is an access token (by jti) that must be rejected until it expires
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey" json:"jti"`
+	UserID    uint      `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// PasswordResetToken is a single-use token emailed by the forgot-password flow
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a hashed single-use backup code for two-factor login
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AuthPolicy holds security requirements enforced for every user of a role
+type AuthPolicy struct {
+	Role             string    `gorm:"primaryKey" json:"role"`
+	RequireTwoFactor bool      `json:"require_two_factor"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Session is one signed-in device. Its ID is the refresh token family ID,
+// which access tokens carry in their sid claim.
+type Session struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index;not null" json:"-"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `gorm:"column:ip" json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
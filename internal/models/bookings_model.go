@@ -2,12 +2,21 @@ package models
 
 import "time"
 
+const (
+	BookingPending   = "pending"
+	BookingConfirmed = "confirmed"
+	BookingCompleted = "completed"
+	BookingCancelled = "cancelled"
+)
+
 type Booking struct {
 	ID            uint      `gorm:"primaryKey" json:"id"`
 	UserID        uint      `gorm:"index" json:"user_id"`
 	User          User      `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE;" json:"user"`
+	// StylistID is the stylist's user ID, the foreign key to stylists.stylist_id
+	// lives in migration 000016 as GORM would put it on the wrong table
 	StylistID     uint      `gorm:"index;not null" json:"stylist_id"`
-	Stylist       Stylist   `gorm:"foreignKey:StylistID;references:ID;constraint:OnDelete:CASCADE;" json:"stylist"`
+	Stylist       Stylist   `gorm:"foreignKey:StylistID;references:StylistID;constraint:-" json:"stylist"`
 	StartTime     time.Time `json:"start_time"`
 	EndTime       time.Time `json:"end_time"`
 	BookingDay    time.Time `json:"booking_day"`
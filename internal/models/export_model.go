@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+)
+
+// DataExport is a personal data archive built in the background for a large account
+type DataExport struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"-"`
+	Status      string     `gorm:"not null;default:pending" json:"status"`
+	FilePath    string     `json:"-"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Error       string     `json:"-"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	StartedAt   *time.Time `json:"started_at"` // when an instance claimed it for building
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// UserIdentity links an external identity provider account to a user
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Provider  string    `gorm:"uniqueIndex:idx_provider_subject;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_provider_subject;not null" json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthState holds the PKCE verifier and nonce of a sign-in in progress
+type OAuthState struct {
+	StateHash    string    `gorm:"primaryKey" json:"-"`
+	Provider     string    `gorm:"not null" json:"provider"`
+	CodeVerifier string    `gorm:"not null" json:"-"`
+	Nonce        string    `gorm:"not null" json:"-"`
+	Role         string    `json:"role"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// To use the table created by migration 000011, GORM would name it o_auth_states
+func (OAuthState) TableName() string {
+	return "oauth_states"
+}
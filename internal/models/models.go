@@ -0,0 +1,23 @@
+package models
+
+// To list every model that has a table, for the schema check
+func All() []interface{} {
+	return []interface{}{
+		&User{},
+		&Stylist{},
+		&Booking{},
+		&RefreshToken{},
+		&RevokedToken{},
+		&Session{},
+		&PasswordResetToken{},
+		&RecoveryCode{},
+		&AuthPolicy{},
+		&OneTimeCode{},
+		&AuthThrottle{},
+		&LoginAttempt{},
+		&UserIdentity{},
+		&OAuthState{},
+		&Notification{},
+		&DataExport{},
+	}
+}
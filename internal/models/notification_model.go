@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+const (
+	NotificationBookingCancelled = "booking_cancelled"
+	NotificationAccountDeletion  = "account_deletion"
+	NotificationDataExportReady  = "data_export_ready"
+)
+
+// Notification is an in-app message for a user, also sent by email
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"-"`
+	Type      string     `gorm:"not null" json:"type"`
+	Title     string     `gorm:"not null" json:"title"`
+	Body      string     `gorm:"not null" json:"body"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposeAccountUnlock     = "account_unlock"
+	PurposePhoneLogin        = "phone_login"
+	// Followed by ":" and the E.164 number the code was sent to
+	PurposePhoneVerification = "phone_verify"
+)
+
+// OneTimeCode is a short-lived hashed code sent to a user for a single purpose
+type OneTimeCode struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Purpose    string     `gorm:"not null" json:"purpose"`
+	CodeHash   string     `gorm:"not null" json:"-"`
+	Attempts   int        `gorm:"default:0" json:"attempts"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -6,13 +6,13 @@ import (
 )
 
 type Service struct {
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	Name  string  `json:"name" validate:"required,max=100"`
+	Price float64 `json:"price" validate:"gte=0"`
 }
 
 type SampleOfService struct {
-	Img     string `json:"img_url"`
-	Caption string `json:"caption"`
+	Img     string `json:"img_url" validate:"required,url"`
+	Caption string `json:"caption" validate:"max=255"`
 }
 
 type Stylist struct {
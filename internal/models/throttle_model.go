@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AuthThrottle tracks consecutive failures for one account or IP within a scope
+type AuthThrottle struct {
+	Key           string     `gorm:"primaryKey" json:"key"`
+	Failures      int        `gorm:"default:0" json:"failures"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LoginAttempt is the audit record of a guarded authentication attempt
+type LoginAttempt struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Scope      string    `gorm:"not null" json:"scope"`
+	Identifier string    `gorm:"index;not null" json:"identifier"`
+	IP         string    `gorm:"column:ip;not null" json:"ip"`
+	Success    bool      `json:"success"`
+	CreatedAt  time.Time `json:"created_at"`
+}
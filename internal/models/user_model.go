@@ -1,20 +1,39 @@
 package models
 
+import "time"
+
 const (
 	RoleStylist  = "stylist"
 	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
 )
 
 // User model
 type User struct {
-	ID              uint     `gorm:"primaryKey" json:"id"`
-	Name            string   `json:"name"`
-	Email           string   `json:"email" gorm:"uniqueIndex"`
-	Number          string   `json:"number"`
-	Role            string   `json:"role"`
-	Password        string   `json:"password"`
-	ConfirmPassword string   `json:"confirm_password" gorm:"-"`
-	Location        string   `json:"location"`
-	ProfilePicture  string   `json:"profile_picture"`
-	Stylist         *Stylist `gorm:"foreignKey:StylistID;references:ID"`
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `json:"name"`
+	// Stored in lowercase, the unique index on LOWER(email) lives in migration
+	// 000016 as GORM can't declare an expression index the schema check reads
+	Email           string     `json:"email"`
+	Number          string     `json:"number"`
+	PhoneE164       *string    `json:"phone_e164" gorm:"column:phone_e164;uniqueIndex"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+	Role            string     `json:"role"`
+	// Never serialized, requests bind the password through their own input structs
+	Password        string     `json:"-"`
+	Location        string     `json:"location"`
+	ProfilePicture  string     `json:"profile_picture"`
+	EmailVerified   bool       `json:"email_verified" gorm:"default:false"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	TOTPSecret      string     `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled     bool       `json:"two_factor_enabled" gorm:"column:totp_enabled;default:false"`
+	TOTPEnabledAt   *time.Time `json:"-" gorm:"column:totp_enabled_at"`
+	TOTPLastStep    int64      `json:"-" gorm:"column:totp_last_step;default:0"`
+	// Set while the account waits out the deletion grace period
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	// Set once the purge job has scrubbed the account's personal data
+	AnonymizedAt *time.Time `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Stylist      *Stylist   `gorm:"foreignKey:StylistID;references:ID"`
 }
@@ -0,0 +1,232 @@
+package oidc_test
+
+import (
+	"context"
+	"ezwait/internal/oidc"
+	"ezwait/internal/oidc/oidctest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "https://ezwait.example/api/v1/auth/fake/callback"
+
+func TestSignInWithPKCE(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	idp.Claims = jwt.MapClaims{"sub": "user-1", "email": "ada@example.com", "email_verified": true, "name": "Ada"}
+	p := idp.Provider("fake", redirectURL)
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := mustQuery(t, authURL)
+	if !strings.HasPrefix(authURL, idp.Issuer()+"/authorize?") {
+		t.Fatalf("authorization URL %s is not the discovered endpoint", authURL)
+	}
+	if q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("redirect_uri") != redirectURL {
+		t.Fatalf("authorization URL %s lost the state, nonce or redirect", authURL)
+	}
+	if q.Get("code_challenge") != oidc.CodeChallenge("verifier-1") {
+		t.Fatalf("code_challenge is %q, want the S256 of the verifier", q.Get("code_challenge"))
+	}
+
+	claims, err := p.Exchange(ctx, idp.Authorize(t, authURL), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidc.Claims{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if *claims != want {
+		t.Fatalf("got %+v, want %+v", *claims, want)
+	}
+}
+
+func TestExchangeRefusesWrongVerifier(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	idp.Claims = jwt.MapClaims{"sub": "user-1"}
+	p := idp.Provider("fake", redirectURL)
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Exchange(ctx, idp.Authorize(t, authURL), "someone-elses-verifier", "nonce-1"); err == nil {
+		t.Fatal("exchanged a code with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRefusesNonceMismatch(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	idp.Claims = jwt.MapClaims{"sub": "user-1"}
+	p := idp.Provider("fake", redirectURL)
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Exchange(ctx, idp.Authorize(t, authURL), "verifier-1", "nonce-2")
+	if err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("got %v, want a nonce mismatch", err)
+	}
+}
+
+func TestVerifyIDTokenChecks(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	other := oidctest.New(t, "ezwait")
+	p := idp.Provider("fake", redirectURL)
+
+	tests := []struct {
+		name   string
+		token  func() string
+		nonce  string
+		reason string
+	}{
+		{
+			name:  "valid",
+			token: func() string { return idp.Sign(t, idp.IDTokenClaims("user-1", "nonce-1")) },
+			nonce: "nonce-1",
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := idp.IDTokenClaims("user-1", "nonce-1")
+				claims["aud"] = "another-client"
+				return idp.Sign(t, claims)
+			},
+			nonce:  "nonce-1",
+			reason: "audience",
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := idp.IDTokenClaims("user-1", "nonce-1")
+				claims["iss"] = "https://evil.example"
+				return idp.Sign(t, claims)
+			},
+			nonce:  "nonce-1",
+			reason: "issuer",
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := idp.IDTokenClaims("user-1", "nonce-1")
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return idp.Sign(t, claims)
+			},
+			nonce:  "nonce-1",
+			reason: "expired",
+		},
+		{
+			name:   "nonce mismatch",
+			token:  func() string { return idp.Sign(t, idp.IDTokenClaims("user-1", "nonce-1")) },
+			nonce:  "nonce-2",
+			reason: "nonce mismatch",
+		},
+		{
+			name:   "missing sub",
+			token:  func() string { return idp.Sign(t, idp.IDTokenClaims("", "nonce-1")) },
+			nonce:  "nonce-1",
+			reason: "missing sub",
+		},
+		{
+			// Signed by a key the provider doesn't publish, under a kid it does
+			name: "foreign key",
+			token: func() string {
+				claims := idp.IDTokenClaims("user-1", "nonce-1")
+				return other.Sign(t, claims)
+			},
+			nonce:  "nonce-1",
+			reason: "signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Subject != "user-1" {
+					t.Fatalf("subject is %q, want user-1", claims.Subject)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("got %v, want an error about the %s", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenFindsRotatedKey(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	p := idp.Provider("fake", redirectURL)
+	ctx := context.Background()
+
+	verify := func() {
+		t.Helper()
+		if _, err := p.VerifyIDToken(ctx, idp.Sign(t, idp.IDTokenClaims("user-1", "")), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	verify()
+	verify()
+	if n := idp.JWKSFetches(); n != 1 {
+		t.Fatalf("JWKS fetched %d times for one key, want 1", n)
+	}
+
+	// A token under a kid not seen yet refetches the JWKS
+	idp.RotateKey(t)
+	verify()
+	if n := idp.JWKSFetches(); n != 2 {
+		t.Fatalf("JWKS fetched %d times after a rotation, want 2", n)
+	}
+}
+
+func TestVerifyIDTokenAcceptsStringEmailVerified(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	p := idp.Provider("fake", redirectURL)
+
+	// As Apple sends it
+	claims := idp.IDTokenClaims("user-1", "")
+	claims["email"] = "ada@example.com"
+	claims["email_verified"] = "true"
+
+	got, err := p.VerifyIDToken(context.Background(), idp.Sign(t, claims), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.EmailVerified {
+		t.Fatal(`email_verified "true" was not taken as verified`)
+	}
+}
+
+func TestDiscoveryRefusesOtherIssuer(t *testing.T) {
+	idp := oidctest.New(t, "ezwait")
+	idp.DiscoveryIssuer = "https://evil.example"
+	p := idp.Provider("fake", redirectURL)
+
+	_, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("got %v, want a discovery issuer mismatch", err)
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
@@ -0,0 +1,244 @@
+// Package oidctest runs a fake OpenID Connect provider on an httptest server,
+// for testing sign-in without Google or Apple. It serves discovery, a JWKS
+// that can be rotated and a token endpoint that checks the PKCE verifier, and
+// signs ID tokens with the nonce of the authorization they were issued for.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"ezwait/internal/oidc"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IdP is a fake identity provider, closed when the test that made it ends
+type IdP struct {
+	Server   *httptest.Server
+	ClientID string
+	// Claims are added to every ID token the token endpoint issues, sub and
+	// email for a start
+	Claims jwt.MapClaims
+	// DiscoveryIssuer, when set, is the issuer discovery reports instead of the server URL
+	DiscoveryIssuer string
+
+	mu          sync.Mutex
+	keys        []signingKey // the last one signs
+	grants      map[string]grant
+	codes       int // codes issued so far
+	jwksFetches int
+}
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// grant is an authorization waiting for its code to be exchanged
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+// To start a fake IdP for clientID with one signing key
+func New(t testing.TB, clientID string) *IdP {
+	t.Helper()
+
+	idp := &IdP{ClientID: clientID, Claims: jwt.MapClaims{}, grants: map[string]grant{}}
+	idp.RotateKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Server.Close)
+
+	return idp
+}
+
+// To get the issuer ID tokens are signed as
+func (idp *IdP) Issuer() string {
+	return idp.Server.URL
+}
+
+// To build a provider named name that signs in with this IdP
+func (idp *IdP) Provider(name, redirectURL string) *oidc.Provider {
+	return &oidc.Provider{
+		Name:        name,
+		Issuer:      idp.Issuer(),
+		ClientID:    idp.ClientID,
+		RedirectURL: redirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+		HTTPClient:  idp.Server.Client(),
+	}
+}
+
+// To approve the sign-in at authURL, as the user would in the browser, and
+// return the code the IdP redirects back with
+func (idp *IdP) Authorize(t testing.TB, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != idp.ClientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL without the client ID or an S256 challenge: %s", authURL)
+	}
+
+	idp.mu.Lock()
+	idp.codes++
+	code := fmt.Sprintf("code-%d", idp.codes)
+	idp.grants[code] = grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+
+	return code
+}
+
+// To add a signing key, which signs from now on, and return its kid. The
+// older keys stay in the JWKS.
+func (idp *IdP) RotateKey(t testing.TB) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating signing key: %v", err)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	kid := fmt.Sprintf("key-%d", len(idp.keys)+1)
+	idp.keys = append(idp.keys, signingKey{kid: kid, key: key})
+	return kid
+}
+
+// To sign claims as an ID token with the newest key
+func (idp *IdP) Sign(t testing.TB, claims jwt.MapClaims) string {
+	t.Helper()
+
+	signed, err := idp.sign(claims)
+	if err != nil {
+		t.Fatalf("signing ID token: %v", err)
+	}
+	return signed
+}
+
+func (idp *IdP) sign(claims jwt.MapClaims) (string, error) {
+	idp.mu.Lock()
+	current := idp.keys[len(idp.keys)-1]
+	idp.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = current.kid
+	return token.SignedString(current.key)
+}
+
+// To build the claims of a valid ID token for subject, before Claims are added
+func (idp *IdP) IDTokenClaims(subject, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   idp.Issuer(),
+		"aud":   idp.ClientID,
+		"sub":   subject,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// To count the JWKS requests, which show when signing keys were refetched
+func (idp *IdP) JWKSFetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksFetches
+}
+
+func (idp *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := idp.Issuer()
+	if idp.DiscoveryIssuer != "" {
+		issuer = idp.DiscoveryIssuer
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": idp.Issuer() + "/authorize",
+		"token_endpoint":         idp.Issuer() + "/token",
+		"jwks_uri":               idp.Issuer() + "/jwks",
+	})
+}
+
+func (idp *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwksFetches++
+
+	keys := make([]map[string]string, 0, len(idp.keys))
+	for _, k := range idp.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": k.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// To exchange a code for an ID token, refusing it unless the code verifier
+// matches the challenge of its authorization. A code is good for one try.
+func (idp *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	g, ok := idp.grants[code]
+	delete(idp.grants, code)
+	idp.mu.Unlock()
+
+	if !ok || r.PostForm.Get("client_id") != idp.ClientID ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := idp.IDTokenClaims("", g.nonce)
+	for name, value := range idp.Claims {
+		claims[name] = value
+	}
+
+	idToken, err := idp.sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"token_type": "Bearer",
+		"id_token":   idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,128 @@
+package oidc
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"ezwait/config"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuers used when OIDC_<NAME>_ISSUER is not set
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+	"apple":  "https://appleid.apple.com",
+}
+
+// Providers is the set of sign-in providers, looked up by name
+type Providers struct {
+	mu     sync.RWMutex
+	byName map[string]*Provider
+}
+
+// To create an empty provider set
+func NewProviders() *Providers {
+	return &Providers{byName: map[string]*Provider{}}
+}
+
+// To register a provider, replacing any with the same name
+func (ps *Providers) Register(p *Provider) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.byName[p.Name] = p
+}
+
+// To look up a configured provider
+func (ps *Providers) Get(name string) (*Provider, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	p, ok := ps.byName[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// To build the set of configured providers. Issuer falls back to the
+// well-known one for google and apple, and any other OIDC provider, such as
+// a local fake IdP, can be plugged in by giving its issuer.
+func LoadProviders(configs []config.OIDCProvider) (*Providers, error) {
+	providers := NewProviders()
+
+	for _, cfg := range configs {
+		name := strings.ToLower(strings.TrimSpace(cfg.Name))
+
+		p := &Provider{
+			Name:         name,
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+
+		if p.Issuer == "" {
+			p.Issuer = wellKnownIssuers[name]
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc provider %q needs an issuer, client id and redirect url", name)
+		}
+
+		if name == "apple" {
+			p.Scopes = []string{"openid", "email", "name"}
+			if p.ClientSecret == "" {
+				secretFunc, err := appleClientSecret(p.ClientID, cfg.TeamID, cfg.KeyID, cfg.PrivateKeyFile)
+				if err != nil {
+					return nil, err
+				}
+				p.ClientSecretFunc = secretFunc
+			}
+		}
+
+		providers.Register(p)
+	}
+
+	return providers, nil
+}
+
+// To build Apple's client secret, an ES256 JWT signed with the developer key
+func appleClientSecret(clientID, teamID, keyID, keyFile string) (func() (string, error), error) {
+	if teamID == "" || keyID == "" || keyFile == "" {
+		return nil, fmt.Errorf("apple sign-in needs OIDC_APPLE_TEAM_ID, OIDC_APPLE_KEY_ID and OIDC_APPLE_PRIVATE_KEY_FILE")
+	}
+
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading apple private key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("apple private key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing apple private key: %w", err)
+	}
+
+	return func() (string, error) {
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": teamID,
+			"iat": now.Unix(),
+			"exp": now.Add(5 * time.Minute).Unix(),
+			"aud": "https://appleid.apple.com",
+			"sub": clientID,
+		})
+		token.Header["kid"] = keyID
+
+		return token.SignedString(key)
+	}, nil
+}
@@ -0,0 +1,114 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RangeSource answers k-anonymity range queries the way the Have I Been Pwned
+// API does: given the first 5 hex characters of a password's SHA-1, it
+// returns the remaining 35 characters of every known breached hash.
+type RangeSource interface {
+	Range(prefix string) ([]string, error)
+}
+
+// To check a password against a breached-password source
+func IsBreached(source RangeSource, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+
+	for _, suffix := range suffixes {
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//go:embed breached.txt
+var bundledList string
+
+var (
+	bundledOnce   sync.Once
+	bundledRanges bundledSource
+)
+
+type bundledSource map[string][]string
+
+func (s bundledSource) Range(prefix string) ([]string, error) {
+	return s[strings.ToUpper(prefix)], nil
+}
+
+// To get the list of common passwords shipped with the server
+func Bundled() RangeSource {
+	bundledOnce.Do(func() {
+		bundledRanges = bundledSource{}
+		for _, line := range strings.Split(bundledList, "\n") {
+			line = strings.ToUpper(strings.TrimSpace(line))
+			if len(line) != 40 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			bundledRanges[line[:5]] = append(bundledRanges[line[:5]], line[5:])
+		}
+	})
+
+	return bundledRanges
+}
+
+// DirSource reads range files downloaded from Have I Been Pwned, one file per
+// prefix (Dir/ABCDE.txt) holding "SUFFIX:COUNT" lines
+type DirSource struct {
+	Dir string
+}
+
+func (s DirSource) Range(prefix string) ([]string, error) {
+	f, err := os.Open(filepath.Join(s.Dir, strings.ToUpper(prefix)+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var suffixes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if suffix != "" {
+			suffixes = append(suffixes, strings.ToUpper(suffix))
+		}
+	}
+
+	return suffixes, scanner.Err()
+}
+
+// MultiSource combines sources, e.g. the bundled list and a downloaded one
+type MultiSource []RangeSource
+
+func (m MultiSource) Range(prefix string) ([]string, error) {
+	var suffixes []string
+	for _, source := range m {
+		found, err := source.Range(prefix)
+		if err != nil {
+			return nil, err
+		}
+		suffixes = append(suffixes, found...)
+	}
+
+	return suffixes, nil
+}
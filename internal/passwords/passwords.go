@@ -0,0 +1,88 @@
+// Package passwords holds the password policy, the breached-password check
+// and password hashing (bcrypt or argon2id).
+package passwords
+
+import (
+	"ezwait/config"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only looks at the first 72 bytes, longer passwords are rejected
+const bcryptMaxLength = 72
+
+// MaxLength is the longest password any configuration accepts, so login
+// requests can be bounded before hashing
+const MaxLength = 256
+
+// To build the policy and hasher from the password settings. Every number
+// is range-checked here, errors name the environment variable to fix.
+func New(cfg config.PasswordsConfig) (Policy, *Hasher, error) {
+	hasher := &Hasher{Algorithm: cfg.HashAlgorithm, BcryptCost: cfg.BcryptCost, Argon2: DefaultArgon2Params}
+	policy := Policy{MinLength: cfg.MinLength, MaxLength: cfg.MaxLength}
+
+	if hasher.Algorithm == "" {
+		hasher.Algorithm = AlgoBcrypt
+	}
+	if hasher.Algorithm != AlgoBcrypt && hasher.Algorithm != AlgoArgon2id {
+		return policy, nil, fmt.Errorf("unknown PASSWORD_HASH_ALGO %q", hasher.Algorithm)
+	}
+
+	settings := []struct {
+		name     string
+		value    int
+		min, max int
+	}{
+		{"PASSWORD_MIN_LENGTH", cfg.MinLength, 1, MaxLength},
+		{"PASSWORD_MAX_LENGTH", cfg.MaxLength, 1, MaxLength},
+		{"PASSWORD_BCRYPT_COST", cfg.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost},
+		{"PASSWORD_ARGON2_MEMORY_KB", cfg.Argon2MemoryKB, 8, 4 * 1024 * 1024},
+		{"PASSWORD_ARGON2_ITERATIONS", cfg.Argon2Iterations, 1, 100},
+		{"PASSWORD_ARGON2_PARALLELISM", cfg.Argon2Parallelism, 1, 255},
+	}
+	for _, setting := range settings {
+		if setting.value < setting.min || setting.value > setting.max {
+			return policy, nil, fmt.Errorf("%s must be a number between %d and %d", setting.name, setting.min, setting.max)
+		}
+	}
+	hasher.Argon2.Memory = uint32(cfg.Argon2MemoryKB)
+	hasher.Argon2.Iterations = uint32(cfg.Argon2Iterations)
+	hasher.Argon2.Parallelism = uint8(cfg.Argon2Parallelism)
+
+	if hasher.Algorithm == AlgoBcrypt {
+		if policy.MaxLength > bcryptMaxLength {
+			return policy, nil, fmt.Errorf("PASSWORD_MAX_LENGTH can be at most %d with bcrypt", bcryptMaxLength)
+		}
+		policy.MaxBytes = bcryptMaxLength
+	}
+	if policy.MinLength > policy.MaxLength {
+		return policy, nil, fmt.Errorf("PASSWORD_MIN_LENGTH is greater than PASSWORD_MAX_LENGTH")
+	}
+
+	for _, class := range cfg.Require {
+		class = strings.TrimSpace(class)
+		switch class {
+		case "":
+		case ClassUpper, ClassLower, ClassDigit, ClassSymbol:
+			policy.Require = append(policy.Require, class)
+		default:
+			return policy, nil, fmt.Errorf("unknown character class %q in PASSWORD_REQUIRE", class)
+		}
+	}
+
+	if cfg.CheckBreached {
+		sources := MultiSource{Bundled()}
+		if cfg.BreachedDir != "" {
+			if info, err := os.Stat(cfg.BreachedDir); err != nil || !info.IsDir() {
+				return policy, nil, fmt.Errorf("BREACHED_PASSWORDS_DIR %q is not a directory", cfg.BreachedDir)
+			}
+			sources = append(sources, DirSource{Dir: cfg.BreachedDir})
+		}
+		policy.Breached = sources
+	}
+
+	return policy, hasher, nil
+}
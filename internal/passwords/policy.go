@@ -0,0 +1,141 @@
+package passwords
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy error codes, reported as field codes in validation errors
+const (
+	CodeTooShort     = "too_short"
+	CodeTooLong      = "too_long"
+	CodeTooWeak      = "too_weak"
+	CodePersonalInfo = "contains_personal_info"
+	CodeBreached     = "breached"
+)
+
+// Character classes a policy can require
+const (
+	ClassUpper  = "upper"
+	ClassLower  = "lower"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// PolicyError is returned when a password does not meet the policy
+type PolicyError struct {
+	Code string
+}
+
+func (e *PolicyError) Error() string {
+	return "password rejected: " + e.Code
+}
+
+// Policy decides which new passwords are accepted. Lengths count characters,
+// MaxBytes bounds the encoded size for hashes that have a limit of their own.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// Longest password in bytes, 0 for no limit. bcrypt can't hash more than
+	// 72 bytes, which is fewer than 72 characters outside ASCII.
+	MaxBytes int
+	// Character classes that must each appear at least once
+	Require []string
+	// Known breached passwords, nil skips the check
+	Breached RangeSource
+}
+
+// To check a new password. personal holds the user's email, name and
+// similar values the password must not be built from.
+func (p Policy) Check(password string, personal ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return &PolicyError{Code: CodeTooShort}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return &PolicyError{Code: CodeTooLong}
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return &PolicyError{Code: CodeTooLong}
+	}
+
+	for _, class := range p.Require {
+		if !hasClass(password, class) {
+			return &PolicyError{Code: CodeTooWeak}
+		}
+	}
+
+	if containsPersonalInfo(password, personal) {
+		return &PolicyError{Code: CodePersonalInfo}
+	}
+
+	if p.Breached != nil {
+		breached, err := IsBreached(p.Breached, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return &PolicyError{Code: CodeBreached}
+		}
+	}
+
+	return nil
+}
+
+func hasClass(password, class string) bool {
+	for _, r := range password {
+		switch class {
+		case ClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case ClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case ClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case ClassSymbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// To reject passwords that are, or are built around, the user's email or name
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	var candidates []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		candidates = append(candidates, value)
+
+		// To also catch the local part of an email and each word of a name
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		candidates = append(candidates, strings.Fields(value)...)
+	}
+
+	for _, candidate := range candidates {
+		if password == candidate {
+			return true
+		}
+		// Short values like "Al" would reject too many unrelated passwords
+		if utf8.RuneCountInString(candidate) >= 4 && strings.Contains(password, candidate) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package rbac
+
+import "ezwait/internal/models"
+
+// Permission is a single action a role may be allowed to perform
+type Permission string
+
+const (
+	BookingCreate       Permission = "booking:create"
+	BookingViewOwn      Permission = "booking:view_own"
+	BookingViewAll      Permission = "booking:view_all"
+	BookingEditOwn      Permission = "booking:edit_own"
+	BookingUpdateStatus Permission = "booking:update_status"
+	// Acting on bookings that belong to someone else
+	BookingManageAny Permission = "booking:manage_any"
+
+	StylistView          Permission = "stylist:view"
+	StylistManageProfile Permission = "stylist:manage_profile"
+	StylistModerate      Permission = "stylist:moderate"
+
+	UserManage   Permission = "user:manage"
+	PolicyManage Permission = "policy:manage"
+)
+
+// The permission matrix, every role check in the API goes through it
+var rolePermissions = map[string][]Permission{
+	models.RoleCustomer: {
+		BookingCreate,
+		BookingViewOwn,
+		BookingEditOwn,
+		StylistView,
+	},
+	models.RoleStylist: {
+		BookingViewOwn,
+		BookingUpdateStatus,
+		StylistView,
+		StylistManageProfile,
+	},
+	models.RoleAdmin: {
+		BookingViewOwn,
+		BookingViewAll,
+		BookingUpdateStatus,
+		BookingManageAny,
+		StylistView,
+		StylistModerate,
+		UserManage,
+		PolicyManage,
+	},
+}
+
+var matrix = buildMatrix()
+
+func buildMatrix() map[string]map[Permission]bool {
+	m := map[string]map[Permission]bool{}
+	for role, perms := range rolePermissions {
+		m[role] = map[Permission]bool{}
+		for _, p := range perms {
+			m[role][p] = true
+		}
+	}
+	return m
+}
+
+// To check whether a role has a permission
+func Can(role string, perm Permission) bool {
+	return matrix[role][perm]
+}
+
+// To list the permissions of a role
+func PermissionsFor(role string) []Permission {
+	return append([]Permission(nil), rolePermissions[role]...)
+}
+
+// To check whether a role exists
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
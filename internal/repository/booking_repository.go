@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"ezwait/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BookingFilter narrows a booking list, zero fields match everything
+type BookingFilter struct {
+	UserID    uint
+	StylistID uint
+	Status    string
+}
+
+// BookingRepository loads and stores bookings. Bookings are returned with
+// their User and Stylist loaded.
+type BookingRepository interface {
+	FindByID(ctx context.Context, id uint) (*models.Booking, error)
+	List(ctx context.Context, filter BookingFilter, page Page) ([]models.Booking, error)
+	// HasOverlap reports whether a booking of the stylist on day, other than
+	// exceptID and not cancelled, overlaps start to end
+	HasOverlap(ctx context.Context, stylistID uint, day, start, end time.Time, exceptID uint) (bool, error)
+	// ListEnded returns the bookings with status that ended before t
+	ListEnded(ctx context.Context, status string, t time.Time) ([]models.Booking, error)
+	Create(ctx context.Context, booking *models.Booking) error
+	Save(ctx context.Context, booking *models.Booking) error
+	// WithStylistLock runs fn with a repository whose calls wait for, and
+	// then exclude, every other WithStylistLock on the same stylist, so an
+	// overlap check and the write that follows it can't interleave with
+	// another booking of that stylist
+	WithStylistLock(ctx context.Context, stylistID uint, fn func(BookingRepository) error) error
+}
+
+// First key of the advisory locks taken per stylist, the second is the
+// stylist's user ID
+const stylistLockNamespace = 727_000_002
+
+// GormBookingRepository is the BookingRepository backed by Postgres
+type GormBookingRepository struct {
+	db *gorm.DB
+}
+
+// To build a BookingRepository on db
+func NewBookingRepository(db *gorm.DB) *GormBookingRepository {
+	return &GormBookingRepository{db: db}
+}
+
+func (r *GormBookingRepository) FindByID(ctx context.Context, id uint) (*models.Booking, error) {
+	var booking models.Booking
+	if err := r.db.WithContext(ctx).Preload("User").Preload("Stylist").First(&booking, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &booking, nil
+}
+
+func (r *GormBookingRepository) List(ctx context.Context, filter BookingFilter, page Page) ([]models.Booking, error) {
+	query := r.db.WithContext(ctx).Model(&models.Booking{}).Preload("User").Preload("Stylist")
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.StylistID != 0 {
+		query = query.Where("stylist_id = ?", filter.StylistID)
+	}
+	if filter.Status != "" {
+		query = query.Where("booking_status = ?", filter.Status)
+	}
+
+	var bookings []models.Booking
+	err := query.Order("id ASC").Offset(page.Offset).Limit(page.Limit).Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *GormBookingRepository) HasOverlap(ctx context.Context, stylistID uint, day, start, end time.Time, exceptID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Booking{}).Where(
+		"stylist_id = ? AND booking_day = ? AND start_time < ? AND end_time > ? AND id <> ? AND booking_status <> ?",
+		stylistID, day, end, start, exceptID, models.BookingCancelled,
+	).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *GormBookingRepository) ListEnded(ctx context.Context, status string, t time.Time) ([]models.Booking, error) {
+	var bookings []models.Booking
+	err := r.db.WithContext(ctx).Where("end_time < ? AND booking_status = ?", t, status).Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *GormBookingRepository) Create(ctx context.Context, booking *models.Booking) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(booking).Error
+}
+
+func (r *GormBookingRepository) Save(ctx context.Context, booking *models.Booking) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(booking).Error
+}
+
+func (r *GormBookingRepository) WithStylistLock(ctx context.Context, stylistID uint, fn func(BookingRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Released when the transaction ends
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", stylistLockNamespace, int32(stylistID)).Error; err != nil {
+			return err
+		}
+		return fn(&GormBookingRepository{db: tx})
+	})
+}
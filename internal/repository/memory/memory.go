@@ -0,0 +1,409 @@
+// Package memory keeps users, bookings and stylist profiles in maps, for unit tests
+// of the services without Postgres. The repositories of one Store see each
+// other's data, so bookings come back with their User and Stylist loaded as
+// they do from the Gorm repositories. Everything is safe for concurrent use.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"ezwait/internal/models"
+	"ezwait/internal/repository"
+	"ezwait/internal/utils"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Store holds the data shared by the repositories built on it
+type Store struct {
+	mu       sync.Mutex
+	users    map[uint]models.User
+	stylists map[uint]models.Stylist // by stylist user ID
+	bookings map[uint]models.Booking
+	nextID   uint
+
+	// One lock per stylist for WithStylistLock
+	locksMu sync.Mutex
+	locks   map[uint]*sync.Mutex
+}
+
+// To build an empty store
+func New() *Store {
+	return &Store{
+		users:    map[uint]models.User{},
+		stylists: map[uint]models.Stylist{},
+		bookings: map[uint]models.Booking{},
+		locks:    map[uint]*sync.Mutex{},
+	}
+}
+
+// To add a user, given the next free ID when it has none
+func (s *Store) AddUser(user models.User) models.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.ID == 0 {
+		user.ID = s.newID()
+	}
+	s.users[user.ID] = user
+	return user
+}
+
+func (s *Store) newID() uint {
+	s.nextID++
+	return s.nextID
+}
+
+// To load a stylist with its user, s.mu must be held
+func (s *Store) stylist(userID uint) (models.Stylist, bool) {
+	stylist, ok := s.stylists[userID]
+	if ok {
+		stylist.User = s.users[userID]
+	}
+	return stylist, ok
+}
+
+// To load a booking with its user and stylist, s.mu must be held
+func (s *Store) booking(b models.Booking) models.Booking {
+	b.User = s.users[b.UserID]
+	b.Stylist, _ = s.stylist(b.StylistID)
+	return b
+}
+
+func paginate[T any](items []T, page repository.Page) []T {
+	if page.Offset >= len(items) {
+		return nil
+	}
+	items = items[page.Offset:]
+	if page.Limit > 0 && page.Limit < len(items) {
+		items = items[:page.Limit]
+	}
+	return items
+}
+
+// UserRepository is a repository.UserRepository on a Store
+type UserRepository struct {
+	store *Store
+}
+
+// To build a UserRepository on store
+func NewUserRepository(store *Store) *UserRepository {
+	return &UserRepository{store: store}
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, user := range r.store.users {
+		if user.Email == utils.NormalizeEmail(email) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *UserRepository) EmailTaken(ctx context.Context, email string, exceptID uint) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, user := range r.store.users {
+		if strings.EqualFold(user.Email, email) && user.ID != exceptID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *UserRepository) List(ctx context.Context, role string, page repository.Page) ([]models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var users []models.User
+	for _, user := range r.store.users {
+		if role == "" || user.Role == role {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return paginate(users, page), nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user.ID = r.store.newID()
+	r.store.users[user.ID] = *user
+	return nil
+}
+
+func (r *UserRepository) Update(ctx context.Context, user *models.User, fields map[string]interface{}) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.store.users[user.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	userSchema, err := schema.Parse(&models.User{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+
+	// To set the columns the way Gorm would, on the stored row and on user
+	for column, value := range fields {
+		field := userSchema.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("users has no column %q", column)
+		}
+		for _, target := range []*models.User{&stored, user} {
+			if err := field.Set(ctx, reflect.ValueOf(target).Elem(), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.store.users[user.ID] = stored
+	return nil
+}
+
+// BookingRepository is a repository.BookingRepository on a Store
+type BookingRepository struct {
+	store *Store
+}
+
+// To build a BookingRepository on store
+func NewBookingRepository(store *Store) *BookingRepository {
+	return &BookingRepository{store: store}
+}
+
+func (r *BookingRepository) FindByID(ctx context.Context, id uint) (*models.Booking, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	booking, ok := r.store.bookings[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	booking = r.store.booking(booking)
+	return &booking, nil
+}
+
+func (r *BookingRepository) List(ctx context.Context, filter repository.BookingFilter, page repository.Page) ([]models.Booking, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var bookings []models.Booking
+	for _, b := range r.store.bookings {
+		if filter.UserID != 0 && b.UserID != filter.UserID ||
+			filter.StylistID != 0 && b.StylistID != filter.StylistID ||
+			filter.Status != "" && b.BookingStatus != filter.Status {
+			continue
+		}
+		bookings = append(bookings, r.store.booking(b))
+	}
+	sort.Slice(bookings, func(i, j int) bool { return bookings[i].ID < bookings[j].ID })
+
+	return paginate(bookings, page), nil
+}
+
+func (r *BookingRepository) HasOverlap(ctx context.Context, stylistID uint, day, start, end time.Time, exceptID uint) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, b := range r.store.bookings {
+		if b.StylistID == stylistID && sameDay(b.BookingDay, day) &&
+			b.StartTime.Before(end) && b.EndTime.After(start) &&
+			b.ID != exceptID && b.BookingStatus != models.BookingCancelled {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *BookingRepository) ListEnded(ctx context.Context, status string, t time.Time) ([]models.Booking, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var bookings []models.Booking
+	for _, b := range r.store.bookings {
+		if b.EndTime.Before(t) && b.BookingStatus == status {
+			bookings = append(bookings, b)
+		}
+	}
+	sort.Slice(bookings, func(i, j int) bool { return bookings[i].ID < bookings[j].ID })
+
+	return bookings, nil
+}
+
+func (r *BookingRepository) Create(ctx context.Context, booking *models.Booking) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	booking.ID = r.store.newID()
+	r.store.bookings[booking.ID] = *booking
+	return nil
+}
+
+func (r *BookingRepository) Save(ctx context.Context, booking *models.Booking) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if booking.ID == 0 {
+		booking.ID = r.store.newID()
+	}
+	r.store.bookings[booking.ID] = *booking
+	return nil
+}
+
+func (r *BookingRepository) WithStylistLock(ctx context.Context, stylistID uint, fn func(repository.BookingRepository) error) error {
+	r.store.locksMu.Lock()
+	lock, ok := r.store.locks[stylistID]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.store.locks[stylistID] = lock
+	}
+	r.store.locksMu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	return fn(r)
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// StylistRepository is a repository.StylistRepository on a Store
+type StylistRepository struct {
+	store *Store
+}
+
+// To build a StylistRepository on store
+func NewStylistRepository(store *Store) *StylistRepository {
+	return &StylistRepository{store: store}
+}
+
+func (r *StylistRepository) FindByUserID(ctx context.Context, userID uint) (*models.Stylist, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stylist, ok := r.store.stylists[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &stylist, nil
+}
+
+func (r *StylistRepository) FindWithUser(ctx context.Context, userID uint) (*models.Stylist, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stylist, ok := r.store.stylist(userID)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &stylist, nil
+}
+
+func (r *StylistRepository) List(ctx context.Context, filter repository.StylistFilter, page repository.Page) ([]models.Stylist, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var stylists []models.Stylist
+	for userID := range r.store.stylists {
+		stylist, _ := r.store.stylist(userID)
+		if stylist.User.DeletionRequestedAt != nil {
+			continue
+		}
+		if filter.Service != "" && !offers(stylist, filter.Service) {
+			continue
+		}
+		stylists = append(stylists, stylist)
+	}
+
+	// Map order is random, so ties keep ID order
+	sort.Slice(stylists, func(i, j int) bool { return stylists[i].ID < stylists[j].ID })
+	switch filter.Sort {
+	case repository.SortStylistsByRatings:
+		sort.SliceStable(stylists, func(i, j int) bool { return stylists[i].Ratings > stylists[j].Ratings })
+	case repository.SortStylistsByName:
+		sort.SliceStable(stylists, func(i, j int) bool { return stylists[i].User.Name < stylists[j].User.Name })
+	}
+
+	return paginate(stylists, page), nil
+}
+
+func (r *StylistRepository) Create(ctx context.Context, stylist *models.Stylist) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stylist.ID = r.store.newID()
+	r.store.stylists[stylist.StylistID] = *stylist
+	return nil
+}
+
+func (r *StylistRepository) Save(ctx context.Context, stylist *models.Stylist) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stylist.ID == 0 {
+		stylist.ID = r.store.newID()
+	}
+	r.store.stylists[stylist.StylistID] = *stylist
+	return nil
+}
+
+func (r *StylistRepository) IncrementBookings(ctx context.Context, userID uint) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stylist, ok := r.store.stylists[userID]; ok {
+		stylist.NoOfCustomerBookings++
+		r.store.stylists[userID] = stylist
+	}
+	return nil
+}
+
+// To check whether the stylist's services include one named name, as the
+// JSONB containment query does
+func offers(stylist models.Stylist, name string) bool {
+	var services []models.Service
+	if err := json.Unmarshal(stylist.Services, &services); err != nil {
+		return false
+	}
+	for _, service := range services {
+		if service.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ repository.UserRepository    = (*UserRepository)(nil)
+	_ repository.BookingRepository = (*BookingRepository)(nil)
+	_ repository.StylistRepository = (*StylistRepository)(nil)
+)
@@ -0,0 +1,27 @@
+// Package repository keeps the queries for users, stylists and bookings
+// behind interfaces, so the services run the same against Postgres (the Gorm
+// implementations) and in-memory fakes in tests.
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when a lookup matches no row
+var ErrNotFound = errors.New("record not found")
+
+// Page bounds a list query
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// To report missing rows as ErrNotFound so callers don't depend on GORM
+func translate(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
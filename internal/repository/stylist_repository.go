@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"ezwait/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stylist list orders
+const (
+	SortStylistsByRatings = "ratings"
+	SortStylistsByName    = "name"
+)
+
+// StylistFilter narrows a stylist list
+type StylistFilter struct {
+	// Service keeps stylists offering a service with exactly this name
+	Service string
+	// Sort is SortStylistsByRatings, SortStylistsByName or empty for no order
+	Sort string
+}
+
+// StylistRepository loads and stores stylist profiles. Stylists are looked
+// up by their user ID (Stylist.StylistID).
+type StylistRepository interface {
+	// FindByUserID loads the profile alone
+	FindByUserID(ctx context.Context, userID uint) (*models.Stylist, error)
+	// FindWithUser loads the profile with its User
+	FindWithUser(ctx context.Context, userID uint) (*models.Stylist, error)
+	// List leaves out stylists whose account is being deleted, the stylists
+	// come with their User loaded
+	List(ctx context.Context, filter StylistFilter, page Page) ([]models.Stylist, error)
+	Create(ctx context.Context, stylist *models.Stylist) error
+	Save(ctx context.Context, stylist *models.Stylist) error
+	// IncrementBookings adds one to the stylist's booking count
+	IncrementBookings(ctx context.Context, userID uint) error
+}
+
+// GormStylistRepository is the StylistRepository backed by Postgres
+type GormStylistRepository struct {
+	db *gorm.DB
+}
+
+// To build a StylistRepository on db
+func NewStylistRepository(db *gorm.DB) *GormStylistRepository {
+	return &GormStylistRepository{db: db}
+}
+
+func (r *GormStylistRepository) FindByUserID(ctx context.Context, userID uint) (*models.Stylist, error) {
+	var stylist models.Stylist
+	if err := r.db.WithContext(ctx).Where("stylist_id = ?", userID).First(&stylist).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &stylist, nil
+}
+
+func (r *GormStylistRepository) FindWithUser(ctx context.Context, userID uint) (*models.Stylist, error) {
+	var stylist models.Stylist
+	if err := r.db.WithContext(ctx).Preload("User").Where("stylist_id = ?", userID).First(&stylist).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &stylist, nil
+}
+
+func (r *GormStylistRepository) List(ctx context.Context, filter StylistFilter, page Page) ([]models.Stylist, error) {
+	query := r.db.WithContext(ctx).Model(&models.Stylist{}).Joins("User").
+		Where(`"User".deletion_requested_at IS NULL`)
+
+	if filter.Service != "" {
+		contains, err := json.Marshal([]map[string]string{{"name": filter.Service}})
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("stylists.services @> ?", string(contains))
+	}
+
+	switch filter.Sort {
+	case SortStylistsByRatings:
+		query = query.Order("stylists.ratings DESC")
+	case SortStylistsByName:
+		query = query.Order(`"User".name ASC`)
+	}
+
+	var stylists []models.Stylist
+	err := query.Offset(page.Offset).Limit(page.Limit).Find(&stylists).Error
+	return stylists, err
+}
+
+func (r *GormStylistRepository) Create(ctx context.Context, stylist *models.Stylist) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(stylist).Error
+}
+
+func (r *GormStylistRepository) Save(ctx context.Context, stylist *models.Stylist) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(stylist).Error
+}
+
+func (r *GormStylistRepository) IncrementBookings(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Model(&models.Stylist{}).Where("stylist_id = ?", userID).
+		Update("no_of_customer_bookings", gorm.Expr("no_of_customer_bookings + 1")).Error
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"ezwait/internal/models"
+	"ezwait/internal/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserRepository loads and stores user accounts
+type UserRepository interface {
+	FindByID(ctx context.Context, id uint) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// EmailTaken reports whether another account than exceptID uses email,
+	// compared case-insensitively
+	EmailTaken(ctx context.Context, email string, exceptID uint) (bool, error)
+	// List returns users ordered by ID, only those with role when it is set
+	List(ctx context.Context, role string, page Page) ([]models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	// Update writes fields, keyed by column, to the user's row and to user,
+	// leaving every other column as it is in the database
+	Update(ctx context.Context, user *models.User, fields map[string]interface{}) error
+}
+
+// GormUserRepository is the UserRepository backed by Postgres
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+// To build a UserRepository on db
+func NewUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+func (r *GormUserRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("email = ?", utils.NormalizeEmail(email)).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) EmailTaken(ctx context.Context, email string, exceptID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("email = ? AND id <> ?", utils.NormalizeEmail(email), exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *GormUserRepository) List(ctx context.Context, role string, page Page) ([]models.User, error) {
+	query := r.db.WithContext(ctx).Model(&models.User{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+
+	var users []models.User
+	err := query.Order("id ASC").Offset(page.Offset).Limit(page.Limit).Find(&users).Error
+	return users, err
+}
+
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(user).Error
+}
+
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User, fields map[string]interface{}) error {
+	return r.db.WithContext(ctx).Model(user).Updates(fields).Error
+}
@@ -1,13 +1,64 @@
 package routers
 
 import (
+	"ezwait/config"
 	"ezwait/internal/handlers"
+	"ezwait/internal/health"
+	"ezwait/internal/jobs"
 	"ezwait/internal/middleware"
+	"ezwait/internal/rbac"
+	"ezwait/internal/services"
+	"ezwait/internal/validation"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func SetupRoutes(app *fiber.App) {
+// Handlers are the endpoint groups that are built with their dependencies,
+// along with the middleware that needs the services
+type Handlers struct {
+	Bookings      *handlers.BookingHandler
+	Stylists      *handlers.StylistHandler
+	Users         *handlers.UserHandler
+	Admin         *handlers.AdminHandler
+	Auth          *handlers.AuthHandler
+	TwoFactor     *handlers.TwoFactorHandler
+	Phone         *handlers.PhoneHandler
+	OIDC          *handlers.OIDCHandler
+	Sessions      *handlers.SessionHandler
+	Notifications *handlers.NotificationHandler
+	Exports       *handlers.ExportHandler
+	Health        *handlers.HealthHandler
+
+	Guards *middleware.Guards
+}
+
+// To build the handlers on the services, validating request bodies with validate
+// and running work that outlives a request on tasks. Readiness runs the checks
+// of the registry.
+func NewHandlers(s *services.Services, validate *validation.Validator, tasks *jobs.Tasks, checks *health.Registry) Handlers {
+	return Handlers{
+		Bookings:      handlers.NewBookingHandler(s.Bookings, validate),
+		Stylists:      handlers.NewStylistHandler(s.Stylists, validate),
+		Users:         handlers.NewUserHandler(s.Users, validate),
+		Admin:         handlers.NewAdminHandler(s.Users, s.Stylists, s.TwoFactor, validate),
+		Auth:          handlers.NewAuthHandler(s, validate, tasks),
+		TwoFactor:     handlers.NewTwoFactorHandler(s, validate),
+		Phone:         handlers.NewPhoneHandler(s, validate),
+		OIDC:          handlers.NewOIDCHandler(s, validate),
+		Sessions:      handlers.NewSessionHandler(s.Sessions),
+		Notifications: handlers.NewNotificationHandler(s.Notifications),
+		Exports:       handlers.NewExportHandler(s.Exports),
+		Health:        handlers.NewHealthHandler(checks),
+
+		Guards: middleware.NewGuards(s),
+	}
+}
+
+// To register every route, cfg decides the optional guards
+func SetupRoutes(app *fiber.App, cfg *config.Config, h Handlers) {
+	g := h.Guards
 
 	api := app.Group("/api/v1")
 
@@ -15,12 +66,49 @@ func SetupRoutes(app *fiber.App) {
 		return c.SendString("Welcome to EzWait App")
 	})
 
+	app.Get("/.well-known/jwks.json", h.Auth.JWKSHandler)
+
+	// For liveness and readiness probes
+	app.Get("/healthz", handlers.LivenessHandler)
+	app.Get("/readyz", h.Health.ReadinessHandler)
+
+	// For Prometheus, behind a bearer token when one is configured
+	app.Get("/metrics", middleware.MetricsAuth(cfg.Metrics.Token), adaptor.HTTPHandler(promhttp.Handler()))
+
 	// For Authentication
-	api.Post("/user/register", middleware.ValidateUser, handlers.RegisterHandler)
-	api.Post("/user/login", handlers.LoginHandler)
-	api.Post("/user/logout", handlers.LogoutHandler)
-	api.Put("/user/change-password", middleware.AuthMiddleware, handlers.ChangePassword)
-	api.Delete("/user/delete-account", middleware.AuthMiddleware, handlers.DeleteAccount)
+	api.Post("/user/register", h.Auth.RegisterHandler)
+	api.Post("/user/login", g.BruteForceGuard("login", middleware.EmailFromBody), h.Auth.LoginHandler)
+	api.Post("/user/login/phone", h.Phone.RequestPhoneLoginHandler)
+	api.Post("/user/login/phone/verify", g.BruteForceGuard("otp", g.NumberFromBody), h.Phone.VerifyPhoneLoginHandler)
+	api.Post("/user/login/2fa", g.BruteForceGuard("2fa", g.UserFromChallenge), h.TwoFactor.TwoFactorLoginHandler)
+	api.Post("/user/verify-email", g.BruteForceGuard("otp", middleware.EmailFromBody), h.Auth.VerifyEmailHandler)
+	api.Post("/user/verify-email/resend", h.Auth.ResendVerificationHandler)
+	api.Post("/user/phone", g.AuthMiddleware, h.Phone.RequestPhoneVerificationHandler)
+	api.Post("/user/phone/verify", g.AuthMiddleware, g.BruteForceGuard("otp", middleware.UserFromToken), h.Phone.ConfirmPhoneHandler)
+	api.Post("/user/token/refresh", h.Auth.RefreshTokenHandler)
+	api.Post("/user/logout", g.AuthMiddleware, h.Auth.LogoutHandler)
+	api.Get("/user/sessions", g.AuthMiddleware, h.Sessions.ListSessionsHandler)
+	api.Delete("/user/sessions", g.AuthMiddleware, h.Sessions.RevokeAllSessionsHandler)
+	api.Delete("/user/sessions/:sessionId", g.AuthMiddleware, h.Sessions.RevokeSessionHandler)
+	api.Put("/user/change-password", g.AuthMiddleware, g.BruteForceGuard("change_password", middleware.UserFromToken), h.Auth.ChangePassword)
+	api.Post("/user/forgot-password", h.Auth.ForgotPasswordHandler)
+	api.Post("/user/reset-password", h.Auth.ResetPasswordHandler)
+	api.Post("/user/unlock/request", h.Auth.RequestUnlockHandler)
+	api.Post("/user/unlock", g.BruteForceGuard("otp", middleware.EmailFromBody), h.Auth.UnlockAccountHandler)
+	api.Delete("/user/delete-account", g.AuthMiddleware, h.Auth.DeleteAccount)
+	api.Post("/user/delete-account/cancel", h.Auth.RestoreAccountHandler)
+
+	// For social sign-in (OIDC)
+	api.Get("/auth/:provider/start", h.OIDC.StartOIDCHandler)
+	api.Get("/auth/:provider/callback", h.OIDC.OIDCCallbackHandler)
+	api.Post("/auth/:provider/callback", h.OIDC.OIDCCallbackHandler)
+	api.Post("/auth/oidc/complete", h.OIDC.CompleteOIDCSignupHandler)
+
+	// For two-factor authentication
+	api.Post("/user/2fa/setup", g.AllowTwoFactorEnrollment, h.TwoFactor.SetupTwoFactorHandler)
+	api.Post("/user/2fa/confirm", g.AllowTwoFactorEnrollment, g.BruteForceGuard("2fa", middleware.UserFromToken), h.TwoFactor.ConfirmTwoFactorHandler)
+	api.Post("/user/2fa/disable", g.AuthMiddleware, g.BruteForceGuard("2fa", middleware.UserFromToken), h.TwoFactor.DisableTwoFactorHandler)
+	api.Post("/user/2fa/recovery-codes", g.AuthMiddleware, g.BruteForceGuard("2fa", middleware.UserFromToken), h.TwoFactor.RegenerateRecoveryCodesHandler)
 
 	// To test session
 	app.Get("/test-session", func(c *fiber.Ctx) error {
@@ -35,23 +123,43 @@ func SetupRoutes(app *fiber.App) {
 	})
 
 	// For User Bookings
-	api.Post("/customer/bookings", middleware.AuthMiddleware, middleware.ValidateCustomer, handlers.MakeBooking)
-	api.Get("/view-all/bookings", middleware.AuthMiddleware, handlers.ViewAllBookings)
-	api.Get("/view/bookings/:bookingId", middleware.AuthMiddleware, handlers.ViewSingleBooking)
+	bookingGuards := []fiber.Handler{g.AuthMiddleware, middleware.RequirePermission(rbac.BookingCreate)}
+	if cfg.Accounts.RequireVerifiedEmailForBookings {
+		bookingGuards = append(bookingGuards, g.RequireVerifiedEmail)
+	}
+	api.Post("/customer/bookings", append(bookingGuards, h.Bookings.MakeBooking)...)
+	api.Get("/view-all/bookings", g.AuthMiddleware, middleware.RequirePermission(rbac.BookingViewOwn), h.Bookings.ViewAllBookings)
+	api.Get("/view/bookings/:bookingId", g.AuthMiddleware, middleware.RequirePermission(rbac.BookingViewOwn), h.Bookings.ViewSingleBooking)
+	// For personal data exports, the download link carries its own token
+	api.Get("/user/export", g.AuthMiddleware, h.Exports.ExportDataHandler)
+	api.Get("/user/export/download", h.Exports.DownloadDataExportHandler)
+	api.Get("/user/export/:exportId", g.AuthMiddleware, h.Exports.DataExportStatusHandler)
+
+	// For in-app notifications
+	api.Get("/user/notifications", g.AuthMiddleware, h.Notifications.ListNotificationsHandler)
+	api.Patch("/user/notifications/:notificationId/read", g.AuthMiddleware, h.Notifications.MarkNotificationReadHandler)
+
 	// For user to edit and update details
-	api.Put("/user/edit", middleware.AuthMiddleware, handlers.UpdateUserProfile)
+	api.Put("/user/edit", g.AuthMiddleware, h.Users.UpdateUserProfile)
 
-	api.Put("/customer/edit/bookings/:bookingId", middleware.AuthMiddleware, middleware.ValidateCustomer, handlers.EditBooking)
-	api.Get("/customer/view/all-stylists/", middleware.AuthMiddleware, handlers.ViewAllStylists)
+	api.Put("/customer/edit/bookings/:bookingId", g.AuthMiddleware, middleware.RequirePermission(rbac.BookingEditOwn), h.Bookings.EditBooking)
+	api.Get("/customer/view/all-stylists/", g.AuthMiddleware, middleware.RequirePermission(rbac.StylistView), h.Stylists.ViewAllStylists)
 
-	// api.Patch("/stylists/:id/customers", middleware.AuthMiddleware, handlers.UpdateCurrentCustomers)
+	// api.Patch("/stylists/:id/customers", g.AuthMiddleware, handlers.UpdateCurrentCustomers)
 
 	// Stylist Bookings Profile
-	// api.Get("/stylists/:stylistId/bookings", middleware.AuthMiddleware, middleware.ValidateStylist, handlers.ViewAllBookings)
+	// api.Get("/stylists/:stylistId/bookings", g.AuthMiddleware, middleware.RequirePermission(rbac.BookingViewOwn), h.Bookings.ViewAllBookings)
 
 	// Stylist
-	api.Post("/stylists/profile", middleware.AuthMiddleware, middleware.ValidateStylist, handlers.CreateStylistProfile)
-	api.Get("/stylists/:stylistId/profile", middleware.AuthMiddleware, handlers.ViewStylistProfile)
-	api.Patch("/bookings/:bookingId/status", middleware.AuthMiddleware, middleware.ValidateStylist, handlers.UpdateBookingStatus)
-	api.Patch("/stylists/:stylistId", middleware.AuthMiddleware, middleware.ValidateStylist, handlers.UpdateStylistProfile)
+	api.Post("/stylists/profile", g.AuthMiddleware, middleware.RequirePermission(rbac.StylistManageProfile), h.Stylists.CreateStylistProfile)
+	api.Get("/stylists/:stylistId/profile", g.AuthMiddleware, middleware.RequirePermission(rbac.StylistView), h.Stylists.ViewStylistProfile)
+	api.Patch("/bookings/:bookingId/status", g.AuthMiddleware, middleware.RequirePermission(rbac.BookingUpdateStatus), h.Bookings.UpdateBookingStatus)
+	api.Patch("/stylists/:stylistId", g.AuthMiddleware, middleware.RequirePermission(rbac.StylistManageProfile), h.Stylists.UpdateStylistProfile)
+
+	// Admin
+	admin := api.Group("/admin", g.AuthMiddleware)
+	admin.Get("/users", middleware.RequirePermission(rbac.UserManage), h.Admin.ListUsersHandler)
+	admin.Put("/users/:userId/role", middleware.RequirePermission(rbac.UserManage), h.Admin.UpdateUserRoleHandler)
+	admin.Patch("/stylists/:stylistId/status", middleware.RequirePermission(rbac.StylistModerate), h.Admin.ModerateStylistHandler)
+	admin.Put("/policies/:role/two-factor", middleware.RequirePermission(rbac.PolicyManage), h.Admin.SetTwoFactorPolicyHandler)
 }
@@ -0,0 +1,325 @@
+// Package schemacheck compares the live Postgres schema with the GORM models,
+// so a migration that forgot a column, index or foreign key is caught before
+// a query fails on it.
+package schemacheck
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Drift is one difference between a model and its table
+type Drift struct {
+	Table   string
+	Problem string
+}
+
+func (d Drift) String() string {
+	return d.Table + ": " + d.Problem
+}
+
+type column struct {
+	udtName  string
+	nullable bool
+}
+
+type index struct {
+	columns []string
+	unique  bool
+	partial bool
+}
+
+type foreignKey struct {
+	columns    []string
+	refTable   string
+	refColumns []string
+}
+
+// To compare every model's table in the current schema with the model. The
+// result is empty when they agree; err is only for failed queries.
+func Check(ctx context.Context, db *gorm.DB, models ...interface{}) ([]Drift, error) {
+	db = db.WithContext(ctx)
+
+	var drifts []Drift
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("parsing %T: %w", model, err)
+		}
+
+		found, err := checkTable(db, stmt.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", stmt.Schema.Table, err)
+		}
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, nil
+}
+
+func checkTable(db *gorm.DB, sch *schema.Schema) ([]Drift, error) {
+	table := sch.Table
+	var drifts []Drift
+	drift := func(format string, args ...interface{}) {
+		drifts = append(drifts, Drift{Table: table, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	columns, err := loadColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		drift("table is missing")
+		return drifts, nil
+	}
+
+	// Columns
+	modelColumns := map[string]bool{}
+	for _, field := range sch.Fields {
+		if field.DBName == "" || field.IgnoreMigration {
+			continue
+		}
+		modelColumns[field.DBName] = true
+
+		col, ok := columns[field.DBName]
+		if !ok {
+			drift("column %s is missing", field.DBName)
+			continue
+		}
+		if !typeMatches(field, col.udtName) {
+			drift("column %s is %s, the model has %s", field.DBName, col.udtName, dataType(field))
+		}
+		if (field.NotNull || field.PrimaryKey) && col.nullable {
+			drift("column %s is nullable, the model has NOT NULL", field.DBName)
+		}
+	}
+	for _, name := range sortedKeys(columns) {
+		if !modelColumns[name] {
+			drift("column %s is not in the model", name)
+		}
+	}
+
+	// Indexes
+	indexes, err := loadIndexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(sch.PrimaryFieldDBNames) > 0 && !hasIndex(indexes, sch.PrimaryFieldDBNames, true) {
+		drift("primary key (%s) has no unique index", strings.Join(sch.PrimaryFieldDBNames, ", "))
+	}
+	for _, idx := range sch.ParseIndexes() {
+		cols := make([]string, len(idx.Fields))
+		for i, opt := range idx.Fields {
+			cols[i] = opt.DBName
+		}
+		unique := idx.Class == "UNIQUE"
+		if !hasIndex(indexes, cols, unique) {
+			kind := "index"
+			if unique {
+				kind = "unique index"
+			}
+			drift("%s %s on (%s) is missing", kind, idx.Name, strings.Join(cols, ", "))
+		}
+	}
+	for _, field := range sch.Fields {
+		if field.Unique && !hasIndex(indexes, []string{field.DBName}, true) {
+			drift("unique index on (%s) is missing", field.DBName)
+		}
+	}
+
+	// Foreign keys
+	foreignKeys, err := loadForeignKeys(db, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range sch.Relationships.Relations {
+		constraint := rel.ParseConstraint()
+		if constraint == nil || constraint.Schema != sch {
+			continue
+		}
+
+		want := foreignKey{refTable: constraint.ReferenceSchema.Table}
+		for _, f := range constraint.ForeignKeys {
+			want.columns = append(want.columns, f.DBName)
+		}
+		for _, f := range constraint.References {
+			want.refColumns = append(want.refColumns, f.DBName)
+		}
+		if !hasForeignKey(foreignKeys, want) {
+			drift("foreign key (%s) to %s(%s) is missing", strings.Join(want.columns, ", "), want.refTable, strings.Join(want.refColumns, ", "))
+		}
+	}
+
+	return drifts, nil
+}
+
+func loadColumns(db *gorm.DB, table string) (map[string]column, error) {
+	var rows []struct {
+		ColumnName string
+		UdtName    string
+		IsNullable string
+	}
+	err := db.Raw(`SELECT column_name, udt_name, is_nullable
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?`, table).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]column, len(rows))
+	for _, row := range rows {
+		columns[row.ColumnName] = column{udtName: row.UdtName, nullable: row.IsNullable == "YES"}
+	}
+	return columns, nil
+}
+
+func loadIndexes(db *gorm.DB, table string) ([]index, error) {
+	var rows []struct {
+		Columns   string
+		IsUnique  bool
+		IsPartial bool
+	}
+	err := db.Raw(`SELECT string_agg(a.attname, ',' ORDER BY k.ord) AS columns,
+			ix.indisunique AS is_unique, ix.indpred IS NOT NULL AS is_partial
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE n.nspname = current_schema() AND t.relname = ?
+		GROUP BY ix.indexrelid, ix.indisunique, ix.indpred IS NOT NULL`, table).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]index, len(rows))
+	for i, row := range rows {
+		indexes[i] = index{columns: strings.Split(row.Columns, ","), unique: row.IsUnique, partial: row.IsPartial}
+	}
+	return indexes, nil
+}
+
+func loadForeignKeys(db *gorm.DB, table string) ([]foreignKey, error) {
+	var rows []struct {
+		Columns    string
+		RefTable   string
+		RefColumns string
+	}
+	err := db.Raw(`SELECT string_agg(a.attname, ',' ORDER BY k.ord) AS columns,
+			rt.relname AS ref_table,
+			string_agg(ra.attname, ',' ORDER BY k.ord) AS ref_columns
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_class rt ON rt.oid = c.confrelid
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, refattnum, ord)
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		JOIN pg_attribute ra ON ra.attrelid = rt.oid AND ra.attnum = k.refattnum
+		WHERE c.contype = 'f' AND n.nspname = current_schema() AND t.relname = ?
+		GROUP BY c.oid, rt.relname`, table).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	foreignKeys := make([]foreignKey, len(rows))
+	for i, row := range rows {
+		foreignKeys[i] = foreignKey{
+			columns:    strings.Split(row.Columns, ","),
+			refTable:   row.RefTable,
+			refColumns: strings.Split(row.RefColumns, ","),
+		}
+	}
+	return foreignKeys, nil
+}
+
+// To find an index serving cols: a unique one must cover exactly those
+// columns for every row, any other only needs them as its leading columns
+func hasIndex(indexes []index, cols []string, unique bool) bool {
+	for _, idx := range indexes {
+		if unique {
+			if idx.unique && !idx.partial && sameSet(idx.columns, cols) {
+				return true
+			}
+			continue
+		}
+		if len(idx.columns) >= len(cols) && equal(idx.columns[:len(cols)], cols) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasForeignKey(foreignKeys []foreignKey, want foreignKey) bool {
+	for _, fk := range foreignKeys {
+		if fk.refTable == want.refTable && equal(fk.columns, want.columns) && equal(fk.refColumns, want.refColumns) {
+			return true
+		}
+	}
+	return false
+}
+
+// Postgres types each GORM data type may be stored as
+var typeFamilies = map[schema.DataType][]string{
+	schema.Bool:   {"bool"},
+	schema.Int:    {"int2", "int4", "int8"},
+	schema.Uint:   {"int2", "int4", "int8"},
+	schema.Float:  {"float4", "float8", "numeric"},
+	schema.String: {"varchar", "text", "bpchar"},
+	schema.Time:   {"timestamp", "timestamptz", "date"},
+	schema.Bytes:  {"bytea"},
+}
+
+func typeMatches(field *schema.Field, udtName string) bool {
+	if family, ok := typeFamilies[field.DataType]; ok {
+		for _, name := range family {
+			if name == udtName {
+				return true
+			}
+		}
+		return false
+	}
+
+	// To compare a custom type such as jsonb by name
+	return strings.EqualFold(dataType(field), udtName)
+}
+
+func dataType(field *schema.Field) string {
+	return string(field.DataType)
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return equal(a, b)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(columns map[string]column) []string {
+	keys := make([]string, 0, len(columns))
+	for key := range columns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/pkg/logger"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDeletionAlreadyRequested = errors.New("account deletion has already been requested")
+	ErrNoDeletionPending        = errors.New("account is not scheduled for deletion")
+)
+
+// AccountService schedules accounts for deletion, restores them during the
+// grace period of accounts.DeletionGraceDays and anonymizes them after it
+type AccountService struct {
+	db            *gorm.DB
+	accounts      config.AccountsConfig
+	sessions      *SessionService
+	notifications *NotificationService
+}
+
+// To build an AccountService, sessions signs deleted accounts out and
+// notifications tells the other side of their cancelled bookings
+func NewAccountService(db *gorm.DB, accounts config.AccountsConfig, sessions *SessionService, notifications *NotificationService) *AccountService {
+	return &AccountService{db: db, accounts: accounts, sessions: sessions, notifications: notifications}
+}
+
+// To read how long a deleted account can still be restored
+func (s *AccountService) DeletionGracePeriod() time.Duration {
+	return time.Duration(s.accounts.DeletionGraceDays) * 24 * time.Hour
+}
+
+// To schedule an account for deletion: the user is signed out everywhere,
+// their upcoming bookings are cancelled and the other side of each booking is
+// notified. The account can be restored until the purge job anonymizes it.
+func (s *AccountService) RequestAccountDeletion(ctx context.Context, user *models.User) error {
+	if user.DeletionRequestedAt != nil {
+		return ErrDeletionAlreadyRequested
+	}
+
+	now := time.Now()
+	scheduled := now.Add(s.DeletionGracePeriod())
+
+	var cancelled []models.Booking
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"deletion_requested_at": now,
+			"deletion_scheduled_at": scheduled,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("(user_id = ? OR stylist_id = ?) AND start_time > ? AND booking_status IN ?",
+			user.ID, user.ID, now, []string{models.BookingPending, models.BookingConfirmed}).
+			Find(&cancelled).Error; err != nil {
+			return err
+		}
+
+		if len(cancelled) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(cancelled))
+		for _, b := range cancelled {
+			ids = append(ids, b.ID)
+		}
+
+		return tx.Model(&models.Booking{}).Where("id IN ?", ids).Update("booking_status", models.BookingCancelled).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.sessions.RevokeUserTokens(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// To tell the other side of each booking, failures must not undo the deletion request
+	for _, b := range cancelled {
+		counterpart := b.UserID
+		if counterpart == user.ID {
+			counterpart = b.StylistID
+		}
+
+		body := fmt.Sprintf("Your booking on %s at %s has been cancelled because the other party closed their EzWait account.",
+			b.BookingDay.Format("Monday 2 January 2006"), b.StartTime.Format("15:04"))
+		if err := s.notifications.Notify(ctx, counterpart, models.NotificationBookingCancelled, "Booking cancelled", body); err != nil {
+			logger.Error(ctx, "Failed to notify about cancelled booking", "booking_id", b.ID, "user_id", counterpart, "error", err)
+		}
+	}
+
+	body := fmt.Sprintf("Your EzWait account will be deleted on %s. Log in before then if you change your mind.",
+		scheduled.Format("Monday 2 January 2006"))
+	if err := s.notifications.Notify(ctx, user.ID, models.NotificationAccountDeletion, "Your account is scheduled for deletion", body); err != nil {
+		logger.Error(ctx, "Failed to notify about account deletion", "user_id", user.ID, "error", err)
+	}
+
+	user.DeletionRequestedAt = &now
+	user.DeletionScheduledAt = &scheduled
+
+	return nil
+}
+
+// To restore an account during its grace period. Cancelled bookings stay cancelled.
+func (s *AccountService) CancelAccountDeletion(ctx context.Context, userID uint) error {
+	result := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND deletion_requested_at IS NOT NULL AND anonymized_at IS NULL", userID).
+		Updates(map[string]interface{}{
+			"deletion_requested_at": nil,
+			"deletion_scheduled_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoDeletionPending
+	}
+
+	return nil
+}
+
+// To anonymize every account whose grace period has ended, returning how many were purged
+func (s *AccountService) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	var users []models.User
+	if err := s.db.WithContext(ctx).Where("deletion_scheduled_at <= ? AND anonymized_at IS NULL", time.Now()).
+		Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range users {
+		if err := s.anonymizeUser(ctx, &users[i]); err != nil {
+			return purged, fmt.Errorf("purging user %d: %w", users[i].ID, err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// To scrub a user's personal data while keeping the row, so the other side
+// of past bookings still sees the booking history, with "Deleted user" as the name
+func (s *AccountService) anonymizeUser(ctx context.Context, user *models.User) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.OneTimeCode{},
+			&models.PasswordResetToken{},
+			&models.RecoveryCode{},
+			&models.UserIdentity{},
+			&models.Notification{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// To let the cleanup job remove any export files right away
+		if err := tx.Model(&models.DataExport{}).Where("user_id = ?", user.ID).Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		identifiers := []string{strings.ToLower(user.Email), strconv.Itoa(int(user.ID))}
+		if user.PhoneE164 != nil {
+			identifiers = append(identifiers, *user.PhoneE164)
+		}
+		if err := tx.Where("identifier IN ?", identifiers).Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Stylist{}).Where("stylist_id = ?", user.ID).Updates(map[string]interface{}{
+			"active_status":        false,
+			"profile_picture":      "",
+			"sample_of_services":   "[]",
+			"available_time_slots": "[]",
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).Where("id = ? AND anonymized_at IS NULL", user.ID).Updates(map[string]interface{}{
+			"name":              "Deleted user",
+			"email":             fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			"number":            "",
+			"phone_e164":        nil,
+			"phone_verified_at": nil,
+			"password":          "",
+			"location":          "",
+			"profile_picture":   "",
+			"email_verified":    false,
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"anonymized_at":     time.Now(),
+		}).Error
+	})
+}
@@ -1 +1,239 @@
 package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/internal/utils"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// SessionService starts, refreshes and ends login sessions. Access tokens
+// live for accessTTL, refresh tokens and their sessions for refreshTTL.
+type SessionService struct {
+	db         *gorm.DB
+	keys       *utils.KeyRing
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// To build a SessionService signing access tokens with keys, for the token
+// lifetimes of the JWT settings
+func NewSessionService(db *gorm.DB, keys *utils.KeyRing, jwt config.JWTConfig) *SessionService {
+	return &SessionService{db: db, keys: keys, accessTTL: jwt.AccessTTL, refreshTTL: jwt.RefreshTTL}
+}
+
+// TokenPair is what a client receives after login or refresh
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+	ExpiresIn    int
+}
+
+// To start a new session on a device and issue its first access + refresh token pair
+func (s *SessionService) IssueTokenPair(ctx context.Context, user *models.User, device SessionDevice) (*TokenPair, error) {
+	familyID, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Create(&models.Session{
+			ID:         familyID,
+			UserID:     user.ID,
+			DeviceName: truncate(device.Name, 100),
+			UserAgent:  truncate(device.UserAgent, 512),
+			IP:         device.IP,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(s.refreshTTL),
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = s.issueTokenPair(tx, user, familyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (s *SessionService) issueTokenPair(db *gorm.DB, user *models.User, familyID string) (*TokenPair, error) {
+	accessToken, jti, accessExpiresAt, err := s.keys.GenerateToken(user, familyID, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.RefreshToken{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       utils.HashToken(refreshToken),
+		AccessJTI:       jti,
+		AccessExpiresAt: accessExpiresAt,
+		ExpiresAt:       time.Now().Add(s.refreshTTL),
+		CreatedAt:       time.Now(),
+	}
+
+	if err := db.Create(&record).Error; err != nil {
+		return nil, err
+	}
+
+	// To keep the session alive for as long as its newest refresh token
+	if err := db.Model(&models.Session{}).Where("id = ?", familyID).Updates(map[string]interface{}{
+		"last_seen_at": time.Now(),
+		"expires_at":   record.ExpiresAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		SessionID:    familyID,
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// To exchange a refresh token for a new pair. Presenting an already used token
+// is treated as theft and revokes the whole family.
+func (s *SessionService) RotateRefreshToken(ctx context.Context, rawToken string) (*TokenPair, error) {
+	var current models.RefreshToken
+	err := s.db.WithContext(ctx).Where("token_hash = ?", utils.HashToken(rawToken)).First(&current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if current.UsedAt != nil {
+		if err := s.RevokeFamily(ctx, current.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, current.UserID).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var pair *TokenPair
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// To mark the token as used, losing a concurrent race counts as reuse
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", current.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		var err error
+		pair, err = s.issueTokenPair(tx, &user, current.FamilyID)
+		return err
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if err := s.RevokeFamily(ctx, current.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// To end a session, revoking every refresh token in its family along with the access tokens issued from it
+func (s *SessionService) RevokeFamily(ctx context.Context, familyID string) error {
+	return s.revokeTokens(ctx, "family_id", "id", familyID)
+}
+
+// To end every session of a user, e.g. after a password change
+func (s *SessionService) RevokeUserTokens(ctx context.Context, userID uint) error {
+	return s.revokeTokens(ctx, "user_id", "user_id", userID)
+}
+
+func (s *SessionService) revokeTokens(ctx context.Context, column, sessionColumn string, value interface{}) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(sessionColumn+" = ?", value).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+
+		var tokens []models.RefreshToken
+		if err := tx.Where(column+" = ? AND revoked_at IS NULL", value).Find(&tokens).Error; err != nil {
+			return err
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		var revoked []models.RevokedToken
+		ids := make([]uint, 0, len(tokens))
+		for _, t := range tokens {
+			ids = append(ids, t.ID)
+			if t.AccessExpiresAt.After(now) {
+				revoked = append(revoked, models.RevokedToken{
+					JTI:       t.AccessJTI,
+					UserID:    t.UserID,
+					ExpiresAt: t.AccessExpiresAt,
+					RevokedAt: now,
+				})
+			}
+		}
+
+		if err := tx.Model(&models.RefreshToken{}).Where("id IN ?", ids).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		if len(revoked) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// To check whether an access token has been revoked
+func (s *SessionService) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
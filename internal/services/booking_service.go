@@ -1 +1,251 @@
 package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/metrics"
+	"ezwait/internal/models"
+	"ezwait/internal/rbac"
+	"ezwait/internal/repository"
+	"ezwait/pkg/logger"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrStylistNotFound    = errors.New("stylist not found")
+	ErrBookingNotFound    = errors.New("booking not found")
+	ErrSlotTaken          = errors.New("time slot already booked")
+	ErrNotBookingOwner    = errors.New("booking belongs to someone else")
+	ErrBookingNotEditable = errors.New("only pending bookings can be edited")
+	ErrBookingClosed      = errors.New("booking is completed or cancelled")
+)
+
+// TransitionError is returned when a booking can't move to the requested status
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("a %s booking cannot become %s", e.From, e.To)
+}
+
+// The statuses each booking status may move to, completed and cancelled are final
+var bookingTransitions = map[string][]string{
+	models.BookingPending:   {models.BookingConfirmed, models.BookingCancelled},
+	models.BookingConfirmed: {models.BookingCompleted, models.BookingCancelled},
+}
+
+// BookingSlot is when a booking takes place
+type BookingSlot struct {
+	Day   time.Time
+	Start time.Time
+	End   time.Time
+}
+
+// BookingService holds the booking rules: who may see and change a booking,
+// that a stylist's bookings don't overlap and which status changes are allowed
+type BookingService struct {
+	bookings repository.BookingRepository
+	stylists repository.StylistRepository
+	now      func() time.Time
+}
+
+// To build a BookingService on the given repositories
+func NewBookingService(bookings repository.BookingRepository, stylists repository.StylistRepository) *BookingService {
+	return &BookingService{bookings: bookings, stylists: stylists, now: time.Now}
+}
+
+// To book a stylist for a customer, confirmed right away when the stylist
+// auto-confirms
+func (s *BookingService) Create(ctx context.Context, customerID, stylistID uint, slot BookingSlot) (*models.Booking, error) {
+	stylist, err := s.stylists.FindWithUser(ctx, stylistID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrStylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// To refuse bookings with a stylist whose account is being deleted
+	if stylist.User.DeletionRequestedAt != nil {
+		return nil, ErrStylistNotFound
+	}
+
+	status := models.BookingPending
+	if stylist.AutoConfirm {
+		status = models.BookingConfirmed
+	}
+
+	booking := &models.Booking{
+		UserID:        customerID,
+		StylistID:     stylistID,
+		StartTime:     slot.Start,
+		EndTime:       slot.End,
+		BookingDay:    slot.Day,
+		BookingStatus: status,
+		CreatedAt:     s.now(),
+	}
+
+	// To check and book under the stylist's lock, or two customers could both
+	// see the slot free and both book it
+	err = s.bookings.WithStylistLock(ctx, stylistID, func(bookings repository.BookingRepository) error {
+		if err := checkSlot(ctx, bookings, stylistID, slot, 0); err != nil {
+			return err
+		}
+		return bookings.Create(ctx, booking)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.BookingCreated(booking.BookingStatus)
+
+	if err := s.stylists.IncrementBookings(ctx, stylistID); err != nil {
+		logger.Error(ctx, "Failed to count stylist booking", "stylist_id", stylistID, "error", err)
+	}
+
+	return booking, nil
+}
+
+// To list the bookings a user may see: all of them for staff, their own
+// appointments for a stylist and their own bookings for a customer
+func (s *BookingService) List(ctx context.Context, userID uint, role, status string, page repository.Page) ([]models.Booking, error) {
+	filter := repository.BookingFilter{Status: status}
+	switch {
+	case rbac.Can(role, rbac.BookingViewAll):
+	case role == models.RoleStylist:
+		filter.StylistID = userID
+	default:
+		filter.UserID = userID
+	}
+
+	return s.bookings.List(ctx, filter, page)
+}
+
+// To fetch a booking for its customer, its stylist or staff. Anyone else gets
+// ErrBookingNotFound, so booking IDs can't be probed.
+func (s *BookingService) Get(ctx context.Context, bookingID, userID uint, role string) (*models.Booking, error) {
+	booking, err := s.find(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if booking.UserID != userID && booking.StylistID != userID && !rbac.Can(role, rbac.BookingViewAll) {
+		return nil, ErrBookingNotFound
+	}
+
+	return booking, nil
+}
+
+// To move a customer's pending booking to another slot with the same stylist
+func (s *BookingService) Reschedule(ctx context.Context, bookingID, customerID uint, slot BookingSlot) (*models.Booking, error) {
+	booking, err := s.find(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if booking.UserID != customerID {
+		return nil, ErrNotBookingOwner
+	}
+	if booking.BookingStatus != models.BookingPending {
+		return nil, ErrBookingNotEditable
+	}
+
+	err = s.bookings.WithStylistLock(ctx, booking.StylistID, func(bookings repository.BookingRepository) error {
+		if err := checkSlot(ctx, bookings, booking.StylistID, slot, booking.ID); err != nil {
+			return err
+		}
+
+		booking.StartTime = slot.Start
+		booking.EndTime = slot.End
+		booking.BookingDay = slot.Day
+		return bookings.Save(ctx, booking)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+// To change a booking's status as its stylist, or as staff who may manage any
+// booking, following bookingTransitions
+func (s *BookingService) UpdateStatus(ctx context.Context, bookingID, userID uint, role, status string) (*models.Booking, error) {
+	booking, err := s.find(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if booking.StylistID != userID && !rbac.Can(role, rbac.BookingManageAny) {
+		return nil, ErrNotBookingOwner
+	}
+
+	previous := booking.BookingStatus
+	if _, open := bookingTransitions[previous]; !open {
+		return nil, ErrBookingClosed
+	}
+	if !canTransition(previous, status) {
+		return nil, &TransitionError{From: previous, To: status}
+	}
+
+	booking.BookingStatus = status
+	if err := s.bookings.Save(ctx, booking); err != nil {
+		return nil, err
+	}
+
+	metrics.BookingStatusChanged(previous, status)
+
+	return booking, nil
+}
+
+// To mark confirmed bookings whose end time has passed as completed
+func (s *BookingService) CompleteEnded(ctx context.Context) error {
+	ended, err := s.bookings.ListEnded(ctx, models.BookingConfirmed, s.now())
+	if err != nil {
+		return err
+	}
+
+	for i := range ended {
+		booking := &ended[i]
+		booking.BookingStatus = models.BookingCompleted
+		if err := s.bookings.Save(ctx, booking); err != nil {
+			return err
+		}
+		metrics.BookingStatusChanged(models.BookingConfirmed, models.BookingCompleted)
+		logger.Info(ctx, "Booking marked as completed", "booking_id", booking.ID)
+	}
+
+	return nil
+}
+
+func (s *BookingService) find(ctx context.Context, bookingID uint) (*models.Booking, error) {
+	booking, err := s.bookings.FindByID(ctx, bookingID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBookingNotFound
+	}
+	return booking, err
+}
+
+// To make sure the stylist has no other live booking overlapping slot, with
+// bookings holding the stylist's lock
+func checkSlot(ctx context.Context, bookings repository.BookingRepository, stylistID uint, slot BookingSlot, exceptID uint) error {
+	taken, err := bookings.HasOverlap(ctx, stylistID, slot.Day, slot.Start, slot.End, exceptID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlotTaken
+	}
+	return nil
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range bookingTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
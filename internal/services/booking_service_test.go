@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/models"
+	"ezwait/internal/repository"
+	"ezwait/internal/repository/memory"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Day the test bookings fall on
+var testDay = time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+type bookingFixture struct {
+	service  *BookingService
+	store    *memory.Store
+	stylists *memory.StylistRepository
+	stylist  models.User
+	customer models.User
+	other    models.User
+}
+
+// To build a BookingService on an empty store holding one stylist and two
+// customers
+func newBookingFixture(t *testing.T) *bookingFixture {
+	t.Helper()
+
+	store := memory.New()
+	f := &bookingFixture{
+		store:    store,
+		stylists: memory.NewStylistRepository(store),
+		stylist:  store.AddUser(models.User{Name: "Grace", Role: models.RoleStylist}),
+		customer: store.AddUser(models.User{Name: "Alan", Role: models.RoleCustomer}),
+		other:    store.AddUser(models.User{Name: "Ada", Role: models.RoleCustomer}),
+	}
+	f.service = NewBookingService(memory.NewBookingRepository(store), f.stylists)
+
+	err := f.stylists.Create(context.Background(), &models.Stylist{StylistID: f.stylist.ID, ActiveStatus: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// To describe a slot on testDay from hour:minute for duration
+func slotAt(hour, minute int, duration time.Duration) BookingSlot {
+	start := testDay.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	return BookingSlot{Day: testDay, Start: start, End: start.Add(duration)}
+}
+
+func (f *bookingFixture) book(t *testing.T, customer models.User, slot BookingSlot) *models.Booking {
+	t.Helper()
+
+	booking, err := f.service.Create(context.Background(), customer.ID, f.stylist.ID, slot)
+	if err != nil {
+		t.Fatalf("booking %v: %v", slot.Start, err)
+	}
+	return booking
+}
+
+func TestCreateRejectsOverlap(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+
+	first := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+	if first.BookingStatus != models.BookingPending {
+		t.Fatalf("status %q, want pending", first.BookingStatus)
+	}
+
+	// Overlapping 10:00-11:00 from either side, inside it or around it
+	for _, slot := range []BookingSlot{
+		slotAt(10, 30, time.Hour),
+		slotAt(9, 30, time.Hour),
+		slotAt(10, 15, 30*time.Minute),
+		slotAt(9, 0, 3*time.Hour),
+	} {
+		if _, err := f.service.Create(ctx, f.other.ID, f.stylist.ID, slot); !errors.Is(err, ErrSlotTaken) {
+			t.Fatalf("booking %v: got %v, want ErrSlotTaken", slot.Start, err)
+		}
+	}
+
+	// Back to back, and the same hours on another day, are fine
+	f.book(t, f.other, slotAt(11, 0, time.Hour))
+	f.book(t, f.other, slotAt(9, 0, time.Hour))
+	nextDay := slotAt(10, 0, time.Hour)
+	nextDay.Day = nextDay.Day.AddDate(0, 0, 1)
+	nextDay.Start = nextDay.Start.AddDate(0, 0, 1)
+	nextDay.End = nextDay.End.AddDate(0, 0, 1)
+	f.book(t, f.other, nextDay)
+
+	// A cancelled booking frees its slot
+	if _, err := f.service.UpdateStatus(ctx, first.ID, f.stylist.ID, models.RoleStylist, models.BookingCancelled); err != nil {
+		t.Fatal(err)
+	}
+	f.book(t, f.other, slotAt(10, 15, 30*time.Minute))
+}
+
+// slowOverlapCheck pauses after each overlap check, so bookings racing for a
+// slot all get through the check before any of them is written unless the
+// stylist lock keeps them apart
+type slowOverlapCheck struct {
+	repository.BookingRepository
+}
+
+func (r slowOverlapCheck) HasOverlap(ctx context.Context, stylistID uint, day, start, end time.Time, exceptID uint) (bool, error) {
+	taken, err := r.BookingRepository.HasOverlap(ctx, stylistID, day, start, end, exceptID)
+	time.Sleep(5 * time.Millisecond)
+	return taken, err
+}
+
+func (r slowOverlapCheck) WithStylistLock(ctx context.Context, stylistID uint, fn func(repository.BookingRepository) error) error {
+	return r.BookingRepository.WithStylistLock(ctx, stylistID, func(bookings repository.BookingRepository) error {
+		return fn(slowOverlapCheck{bookings})
+	})
+}
+
+func TestCreateOneOfConcurrentBookings(t *testing.T) {
+	f := newBookingFixture(t)
+	f.service = NewBookingService(slowOverlapCheck{memory.NewBookingRepository(f.store)}, f.stylists)
+
+	const customers = 20
+	errs := make([]error, customers)
+	var wg sync.WaitGroup
+	for i := 0; i < customers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = f.service.Create(context.Background(), f.customer.ID, f.stylist.ID, slotAt(10, 0, time.Hour))
+		}()
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, ErrSlotTaken):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if booked != 1 {
+		t.Fatalf("%d bookings of one slot, want 1", booked)
+	}
+}
+
+func TestCreateChecksStylist(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+
+	// A user without a stylist profile
+	if _, err := f.service.Create(ctx, f.customer.ID, f.other.ID, slotAt(10, 0, time.Hour)); !errors.Is(err, ErrStylistNotFound) {
+		t.Fatalf("got %v, want ErrStylistNotFound", err)
+	}
+
+	// A stylist whose account is being deleted
+	requested := time.Now()
+	leaving := f.store.AddUser(models.User{Name: "Edsger", Role: models.RoleStylist, DeletionRequestedAt: &requested})
+	if err := f.stylists.Create(ctx, &models.Stylist{StylistID: leaving.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.service.Create(ctx, f.customer.ID, leaving.ID, slotAt(10, 0, time.Hour)); !errors.Is(err, ErrStylistNotFound) {
+		t.Fatalf("got %v, want ErrStylistNotFound", err)
+	}
+
+	// Auto-confirming stylists get confirmed bookings and every booking is counted
+	stylist, err := f.stylists.FindByUserID(ctx, f.stylist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stylist.AutoConfirm = true
+	if err := f.stylists.Save(ctx, stylist); err != nil {
+		t.Fatal(err)
+	}
+	if booking := f.book(t, f.customer, slotAt(10, 0, time.Hour)); booking.BookingStatus != models.BookingConfirmed {
+		t.Fatalf("status %q, want confirmed", booking.BookingStatus)
+	}
+	if stylist, _ = f.stylists.FindByUserID(ctx, f.stylist.ID); stylist.NoOfCustomerBookings != 1 {
+		t.Fatalf("stylist has %d bookings, want 1", stylist.NoOfCustomerBookings)
+	}
+}
+
+func TestGetHidesOtherPeoplesBookings(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	booking := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+
+	for _, viewer := range []struct {
+		id   uint
+		role string
+		want error
+	}{
+		{f.customer.ID, models.RoleCustomer, nil},
+		{f.stylist.ID, models.RoleStylist, nil},
+		{f.other.ID, models.RoleAdmin, nil},
+		{f.other.ID, models.RoleCustomer, ErrBookingNotFound},
+	} {
+		if _, err := f.service.Get(ctx, booking.ID, viewer.id, viewer.role); !errors.Is(err, viewer.want) {
+			t.Fatalf("user %d as %s: got %v, want %v", viewer.id, viewer.role, err, viewer.want)
+		}
+	}
+
+	if _, err := f.service.Get(ctx, booking.ID+100, f.customer.ID, models.RoleCustomer); !errors.Is(err, ErrBookingNotFound) {
+		t.Fatalf("unknown booking: got %v, want ErrBookingNotFound", err)
+	}
+}
+
+func TestListShowsOwnBookings(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	f.book(t, f.customer, slotAt(10, 0, time.Hour))
+	f.book(t, f.other, slotAt(11, 0, time.Hour))
+
+	page := repository.Page{Limit: 10}
+	for _, viewer := range []struct {
+		id   uint
+		role string
+		want int
+	}{
+		{f.customer.ID, models.RoleCustomer, 1},
+		{f.stylist.ID, models.RoleStylist, 2},
+		{f.other.ID, models.RoleAdmin, 2},
+	} {
+		bookings, err := f.service.List(ctx, viewer.id, viewer.role, "", page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookings) != viewer.want {
+			t.Fatalf("user %d as %s sees %d bookings, want %d", viewer.id, viewer.role, len(bookings), viewer.want)
+		}
+	}
+}
+
+func TestRescheduleOwnership(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	booking := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+	f.book(t, f.other, slotAt(14, 0, time.Hour))
+
+	if _, err := f.service.Reschedule(ctx, booking.ID, f.other.ID, slotAt(12, 0, time.Hour)); !errors.Is(err, ErrNotBookingOwner) {
+		t.Fatalf("someone else's booking: got %v, want ErrNotBookingOwner", err)
+	}
+	if _, err := f.service.Reschedule(ctx, booking.ID, f.customer.ID, slotAt(14, 30, time.Hour)); !errors.Is(err, ErrSlotTaken) {
+		t.Fatalf("onto another booking: got %v, want ErrSlotTaken", err)
+	}
+
+	// Moving within its own slot doesn't clash with itself
+	moved, err := f.service.Reschedule(ctx, booking.ID, f.customer.ID, slotAt(10, 30, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moved.StartTime.Equal(slotAt(10, 30, 0).Start) {
+		t.Fatalf("booking starts at %v", moved.StartTime)
+	}
+
+	// Only pending bookings can move
+	if _, err := f.service.UpdateStatus(ctx, booking.ID, f.stylist.ID, models.RoleStylist, models.BookingConfirmed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.service.Reschedule(ctx, booking.ID, f.customer.ID, slotAt(12, 0, time.Hour)); !errors.Is(err, ErrBookingNotEditable) {
+		t.Fatalf("confirmed booking: got %v, want ErrBookingNotEditable", err)
+	}
+}
+
+func TestUpdateStatusFollowsTransitions(t *testing.T) {
+	statuses := []string{models.BookingPending, models.BookingConfirmed, models.BookingCompleted, models.BookingCancelled}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			f := newBookingFixture(t)
+			ctx := context.Background()
+
+			booking := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+			booking.BookingStatus = from
+			if err := memory.NewBookingRepository(f.store).Save(ctx, booking); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := f.service.UpdateStatus(ctx, booking.ID, f.stylist.ID, models.RoleStylist, to)
+
+			_, open := bookingTransitions[from]
+			var transitionErr *TransitionError
+			switch {
+			case !open:
+				if !errors.Is(err, ErrBookingClosed) {
+					t.Errorf("%s to %s: got %v, want ErrBookingClosed", from, to, err)
+				}
+			case canTransition(from, to):
+				if err != nil {
+					t.Errorf("%s to %s: %v", from, to, err)
+				}
+			case !errors.As(err, &transitionErr):
+				t.Errorf("%s to %s: got %v, want a TransitionError", from, to, err)
+			}
+		}
+	}
+}
+
+func TestUpdateStatusOwnership(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	booking := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+
+	// Not the booking's stylist, even its own customer
+	if _, err := f.service.UpdateStatus(ctx, booking.ID, f.customer.ID, models.RoleCustomer, models.BookingCancelled); !errors.Is(err, ErrNotBookingOwner) {
+		t.Fatalf("customer: got %v, want ErrNotBookingOwner", err)
+	}
+
+	// Staff may manage any booking
+	updated, err := f.service.UpdateStatus(ctx, booking.ID, f.other.ID, models.RoleAdmin, models.BookingConfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.BookingStatus != models.BookingConfirmed {
+		t.Fatalf("status %q, want confirmed", updated.BookingStatus)
+	}
+}
+
+func TestCompleteEnded(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+
+	confirmed := f.book(t, f.customer, slotAt(10, 0, time.Hour))
+	pending := f.book(t, f.customer, slotAt(12, 0, time.Hour))
+	later := f.book(t, f.customer, slotAt(16, 0, time.Hour))
+	for _, booking := range []*models.Booking{confirmed, later} {
+		if _, err := f.service.UpdateStatus(ctx, booking.ID, f.stylist.ID, models.RoleStylist, models.BookingConfirmed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.service.now = func() time.Time { return slotAt(15, 0, 0).Start }
+	if err := f.service.CompleteEnded(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		booking *models.Booking
+		status  string
+	}{
+		{confirmed, models.BookingCompleted},
+		{pending, models.BookingPending},
+		{later, models.BookingConfirmed},
+	} {
+		booking, err := f.service.Get(ctx, want.booking.ID, f.customer.ID, models.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if booking.BookingStatus != want.status {
+			t.Errorf("booking at %v is %s, want %s", booking.StartTime, booking.BookingStatus, want.status)
+		}
+	}
+}
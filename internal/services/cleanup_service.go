@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"ezwait/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CleanupService removes auth data nothing can use anymore
+type CleanupService struct {
+	db *gorm.DB
+}
+
+// To build a CleanupService on db
+func NewCleanupService(db *gorm.DB) *CleanupService {
+	return &CleanupService{db: db}
+}
+
+// To delete expired tokens, codes, sessions and stale throttles
+func (s *CleanupService) CleanupExpiredAuthData(ctx context.Context) error {
+	now := time.Now()
+
+	for _, model := range []interface{}{
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.Session{},
+		&models.OneTimeCode{},
+		&models.PasswordResetToken{},
+		&models.OAuthState{},
+	} {
+		if err := s.db.WithContext(ctx).Where("expires_at < ?", now).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// To forget throttles that are unlocked and older than the longest policy window
+	return s.db.WithContext(ctx).Where("(locked_until IS NULL OR locked_until < ?) AND last_failure_at < ?",
+		now, now.Add(-AccountThrottlePolicy.Window)).
+		Delete(&models.AuthThrottle{}).Error
+}
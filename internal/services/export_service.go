@@ -0,0 +1,432 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/internal/utils"
+	"ezwait/pkg/logger"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// How long a background export can be downloaded once it is ready
+	DataExportTTL = 24 * time.Hour
+	// Accounts with more bookings and notifications than this get their export built in the background
+	ExportSyncLimit = 1000
+	// Name of the JSON document inside export zips
+	ExportFileName = "ezwait-export.json"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready")
+)
+
+// ExportArchive is everything EzWait stores about a user
+type ExportArchive struct {
+	GeneratedAt        time.Time             `json:"generated_at"`
+	User               ExportUser            `json:"user"`
+	BookingsAsCustomer []ExportBooking       `json:"bookings_as_customer"`
+	BookingsAsStylist  []ExportBooking       `json:"bookings_as_stylist"`
+	StylistProfile     *ExportStylist        `json:"stylist_profile"`
+	Sessions           []models.Session      `json:"sessions"`
+	Notifications      []models.Notification `json:"notifications"`
+	LinkedAccounts     []models.UserIdentity `json:"linked_accounts"`
+}
+
+type ExportUser struct {
+	ID               uint       `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Number           string     `json:"number"`
+	PhoneE164        *string    `json:"phone_e164"`
+	Role             string     `json:"role"`
+	Location         string     `json:"location"`
+	ProfilePicture   string     `json:"profile_picture"`
+	EmailVerified    bool       `json:"email_verified"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+}
+
+type ExportBooking struct {
+	ID            uint      `json:"id"`
+	CustomerID    uint      `json:"customer_id"`
+	StylistID     uint      `json:"stylist_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	BookingDay    time.Time `json:"booking_day"`
+	BookingStatus string    `json:"booking_status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ExportStylist struct {
+	ActiveStatus         bool                     `json:"active_status"`
+	ProfilePicture       string                   `json:"profile_picture"`
+	Ratings              float64                  `json:"ratings"`
+	Services             []models.Service         `json:"services"`
+	SampleOfServices     []models.SampleOfService `json:"sample_of_services"`
+	AvailableTimeSlots   []string                 `json:"available_time_slots"`
+	NoOfCustomerBookings int                      `json:"no_of_customer_bookings"`
+	AutoConfirm          bool                     `json:"auto_confirm"`
+	CreatedAt            time.Time                `json:"created_at"`
+}
+
+// ExportService builds copies of a user's personal data, right away for small
+// accounts and in the background, as zips in exports.Dir, for large ones
+type ExportService struct {
+	db            *gorm.DB
+	keys          *utils.KeyRing
+	exports       config.ExportsConfig
+	notifications *NotificationService
+}
+
+// To build an ExportService signing download links with keys, notifications
+// tells users their export is ready
+func NewExportService(db *gorm.DB, keys *utils.KeyRing, exports config.ExportsConfig, notifications *NotificationService) *ExportService {
+	return &ExportService{db: db, keys: keys, exports: exports, notifications: notifications}
+}
+
+// To count the records an export would hold, to decide whether to build it in the background
+func (s *ExportService) ExportSize(ctx context.Context, userID uint) (int64, error) {
+	var bookings, notifications int64
+	if err := s.db.WithContext(ctx).Model(&models.Booking{}).Where("user_id = ? OR stylist_id = ?", userID, userID).Count(&bookings).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.WithContext(ctx).Model(&models.Notification{}).Where("user_id = ?", userID).Count(&notifications).Error; err != nil {
+		return 0, err
+	}
+
+	return bookings + notifications, nil
+}
+
+// To gather a user's personal data
+func (s *ExportService) BuildExport(ctx context.Context, userID uint) (*ExportArchive, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	archive := &ExportArchive{
+		GeneratedAt: time.Now(),
+		User: ExportUser{
+			ID:               user.ID,
+			Name:             user.Name,
+			Email:            user.Email,
+			Number:           user.Number,
+			PhoneE164:        user.PhoneE164,
+			Role:             user.Role,
+			Location:         user.Location,
+			ProfilePicture:   user.ProfilePicture,
+			EmailVerified:    user.EmailVerified,
+			EmailVerifiedAt:  user.EmailVerifiedAt,
+			TwoFactorEnabled: user.TOTPEnabled,
+		},
+	}
+
+	var err error
+	if archive.BookingsAsCustomer, err = s.exportBookings(ctx, "user_id", userID); err != nil {
+		return nil, err
+	}
+	if archive.BookingsAsStylist, err = s.exportBookings(ctx, "stylist_id", userID); err != nil {
+		return nil, err
+	}
+
+	var stylist models.Stylist
+	err = s.db.WithContext(ctx).Where("stylist_id = ?", userID).First(&stylist).Error
+	if err == nil {
+		profile := &ExportStylist{
+			ActiveStatus:         stylist.ActiveStatus,
+			ProfilePicture:       stylist.ProfilePicture,
+			Ratings:              stylist.Ratings,
+			NoOfCustomerBookings: stylist.NoOfCustomerBookings,
+			AutoConfirm:          stylist.AutoConfirm,
+			CreatedAt:            stylist.CreatedAt,
+		}
+
+		// To decode the JSONB columns, empty ones are left out
+		_ = json.Unmarshal(stylist.Services, &profile.Services)
+		_ = json.Unmarshal(stylist.SampleOfServices, &profile.SampleOfServices)
+		_ = json.Unmarshal(stylist.AvailableTimeSlots, &profile.AvailableTimeSlots)
+
+		archive.StylistProfile = profile
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&archive.Sessions).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&archive.Notifications).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&archive.LinkedAccounts).Error; err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (s *ExportService) exportBookings(ctx context.Context, column string, userID uint) ([]ExportBooking, error) {
+	var bookings []models.Booking
+	if err := s.db.WithContext(ctx).Where(column+" = ?", userID).Order("start_time ASC").Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]ExportBooking, 0, len(bookings))
+	for _, b := range bookings {
+		result = append(result, ExportBooking{
+			ID:            b.ID,
+			CustomerID:    b.UserID,
+			StylistID:     b.StylistID,
+			StartTime:     b.StartTime,
+			EndTime:       b.EndTime,
+			BookingDay:    b.BookingDay,
+			BookingStatus: b.BookingStatus,
+			CreatedAt:     b.CreatedAt,
+		})
+	}
+
+	return result, nil
+}
+
+// To write an export as a zip holding a single JSON document
+func WriteExportZip(w io.Writer, archive *ExportArchive) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     ExportFileName,
+		Method:   zip.Deflate,
+		Modified: archive.GeneratedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(archive); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// To queue a background export, reusing one that is queued or still downloadable
+func (s *ExportService) RequestDataExport(ctx context.Context, userID uint) (*models.DataExport, error) {
+	var existing models.DataExport
+	err := s.db.WithContext(ctx).Where("user_id = ? AND (status IN ? OR (status = ? AND expires_at > ?))",
+		userID, []string{models.ExportPending, models.ExportProcessing}, models.ExportReady, time.Now()).
+		Order("created_at DESC").
+		First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	export := models.DataExport{
+		UserID:    userID,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Create(&export).Error; err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// To look up one of a user's exports
+func (s *ExportService) GetDataExport(ctx context.Context, userID, exportID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// To sign a download link token that stops working when the export expires
+func (s *ExportService) DataExportDownloadToken(export *models.DataExport) (string, error) {
+	if export.Status != models.ExportReady || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return "", ErrExportNotReady
+	}
+
+	return s.keys.GenerateTypedToken(utils.TokenTypeDataExport, jwt.MapClaims{
+		"export": export.ID,
+		"user":   export.UserID,
+	}, time.Until(*export.ExpiresAt))
+}
+
+// To find the export file a download link points to
+func (s *ExportService) OpenDataExport(ctx context.Context, token string) (*models.DataExport, error) {
+	claims, err := s.keys.VerifyTypedToken(token, utils.TokenTypeDataExport)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	exportID, _ := claims["export"].(float64)
+	userID, _ := claims["user"].(float64)
+
+	export, err := s.GetDataExport(ctx, uint(userID), uint(exportID))
+	if err != nil {
+		return nil, err
+	}
+
+	if export.Status != models.ExportReady || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return nil, ErrExportNotFound
+	}
+
+	return export, nil
+}
+
+// To build every queued export, called by the background job
+func (s *ExportService) ProcessDataExports(ctx context.Context) error {
+	// To retry exports left half built by an instance that stopped, counting
+	// from the claim so an export queued long ago isn't taken from its builder
+	if err := s.db.WithContext(ctx).Model(&models.DataExport{}).
+		Where("status = ? AND started_at < ?", models.ExportProcessing, time.Now().Add(-time.Hour)).
+		Update("status", models.ExportPending).Error; err != nil {
+		return err
+	}
+
+	var queued []models.DataExport
+	if err := s.db.WithContext(ctx).Where("status = ?", models.ExportPending).Order("created_at ASC").Find(&queued).Error; err != nil {
+		return err
+	}
+
+	for i := range queued {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// To claim the export so another instance doesn't build it too
+		result := s.db.WithContext(ctx).Model(&models.DataExport{}).
+			Where("id = ? AND status = ?", queued[i].ID, models.ExportPending).
+			Updates(map[string]interface{}{"status": models.ExportProcessing, "started_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.buildDataExport(ctx, &queued[i]); err != nil {
+			logger.Error(ctx, "Data export failed", "export_id", queued[i].ID, "error", err)
+			s.db.WithContext(ctx).Model(&queued[i]).Updates(map[string]interface{}{
+				"status": models.ExportFailed,
+				"error":  err.Error(),
+			})
+		}
+	}
+
+	return nil
+}
+
+func (s *ExportService) buildDataExport(ctx context.Context, export *models.DataExport) error {
+	archive, err := s.BuildExport(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	dir := s.exportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	name, err := utils.RandomToken(16)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s.zip", export.ID, name))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteExportZip(f, archive); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(DataExportTTL)
+	if err := s.db.WithContext(ctx).Model(export).Updates(map[string]interface{}{
+		"status":       models.ExportReady,
+		"file_path":    path,
+		"size_bytes":   info.Size(),
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	body := fmt.Sprintf("Your EzWait data export is ready. Download it from the app before %s.",
+		expiresAt.Format("Monday 2 January 2006 15:04"))
+	if err := s.notifications.Notify(ctx, export.UserID, models.NotificationDataExportReady, "Your data export is ready", body); err != nil {
+		logger.Error(ctx, "Failed to notify about data export", "export_id", export.ID, "error", err)
+	}
+
+	return nil
+}
+
+// To delete expired export files and failed exports older than a day
+func (s *ExportService) CleanupExpiredExports(ctx context.Context) error {
+	var expired []models.DataExport
+	if err := s.db.WithContext(ctx).Where("expires_at < ? OR (status = ? AND created_at < ?)",
+		time.Now(), models.ExportFailed, time.Now().Add(-24*time.Hour)).
+		Find(&expired).Error; err != nil {
+		return err
+	}
+
+	for _, export := range expired {
+		if export.FilePath != "" {
+			if err := os.Remove(export.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+
+		if err := s.db.WithContext(ctx).Delete(&export).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// To read where export files are written, the configured directory or, outside
+// production where Validate requires one, a temp directory
+func (s *ExportService) exportDir() string {
+	if s.exports.Dir != "" {
+		return s.exports.Dir
+	}
+	return filepath.Join(os.TempDir(), "ezwait-exports")
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"ezwait/internal/mailer"
+	"ezwait/internal/models"
+	"ezwait/pkg/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NotificationService keeps the in-app notifications and emails a copy of each
+type NotificationService struct {
+	db     *gorm.DB
+	mailer mailer.Mailer
+}
+
+// To build a NotificationService that emails through mailer
+func NewNotificationService(db *gorm.DB, mailer mailer.Mailer) *NotificationService {
+	return &NotificationService{db: db, mailer: mailer}
+}
+
+// To store an in-app notification for a user and email it to them. A failed
+// email is only logged, the notification stays readable in the app.
+func (s *NotificationService) Notify(ctx context.Context, userID uint, kind, title, body string) error {
+	notification := models.Notification{
+		UserID:    userID,
+		Type:      kind,
+		Title:     title,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Create(&notification).Error; err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).Select("id", "name", "email").First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: title,
+		Body:    "Hi " + user.Name + ",\n\n" + body + "\n",
+	}); err != nil {
+		logger.Error(ctx, "Failed to email notification", "user_id", userID, "error", err)
+	}
+
+	return nil
+}
+
+// To list a user's notifications, newest first
+func (s *NotificationService) ListNotifications(ctx context.Context, userID uint, limit, offset int) ([]models.Notification, error) {
+	var notifications []models.Notification
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&notifications).Error
+
+	return notifications, err
+}
+
+// To mark one of a user's notifications as read, reporting whether it exists
+func (s *NotificationService) MarkNotificationRead(ctx context.Context, userID, notificationID uint) (bool, error) {
+	result := s.db.WithContext(ctx).Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", notificationID, userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// To treat an already read notification as found
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.Notification{}).Where("id = ? AND user_id = ?", notificationID, userID).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/models"
+	"ezwait/internal/oidc"
+	"ezwait/internal/utils"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OAuthStateTTL = 10 * time.Minute
+	OIDCSignupTTL = 15 * time.Minute
+)
+
+var (
+	ErrInvalidOAuthState = errors.New("invalid or expired sign-in state")
+	ErrInvalidRole       = errors.New("role must be stylist or customer")
+	ErrEmailRequired     = errors.New("the identity provider did not share an email address")
+	// The email matches an account that never proved it owns the address, so
+	// linking it would let whoever controls the IdP account take it over
+	ErrEmailNotVerified = errors.New("an account with this email exists, log in with your password and verify your email first")
+)
+
+// OIDCResult is either a signed-in user or, on first sign-in without a role,
+// a signup token to finish registration with
+type OIDCResult struct {
+	User        *models.User
+	SignupToken string
+}
+
+// OIDCService signs users in with the configured providers, linking or
+// creating their accounts
+type OIDCService struct {
+	db        *gorm.DB
+	keys      *utils.KeyRing
+	providers *oidc.Providers
+}
+
+// To build an OIDCService on db, signing signup tokens with keys
+func NewOIDCService(db *gorm.DB, keys *utils.KeyRing, providers *oidc.Providers) *OIDCService {
+	return &OIDCService{db: db, keys: keys, providers: providers}
+}
+
+// To start a sign-in, returning the provider URL the user must visit
+func (s *OIDCService) StartOIDC(ctx context.Context, providerName, role string) (string, error) {
+	provider, err := s.providers.Get(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	if role != "" && !isSelfServiceRole(role) {
+		return "", ErrInvalidRole
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.db.WithContext(ctx).Create(&models.OAuthState{
+		StateHash:    utils.HashToken(state),
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		Role:         role,
+		ExpiresAt:    time.Now().Add(OAuthStateTTL),
+		CreatedAt:    time.Now(),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+// To finish a sign-in from the provider callback
+func (s *OIDCService) FinishOIDC(ctx context.Context, providerName, state, code string) (*OIDCResult, error) {
+	provider, err := s.providers.Get(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// To consume the state so a callback can't be replayed
+	var saved models.OAuthState
+	result := s.db.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("state_hash = ? AND provider = ? AND expires_at > ?", utils.HashToken(state), providerName, time.Now()).
+		Delete(&saved)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidOAuthState
+	}
+
+	claims, err := provider.Exchange(ctx, code, saved.CodeVerifier, saved.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveIdentity(ctx, providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return &OIDCResult{User: user}, nil
+	}
+
+	if saved.Role != "" {
+		user, err := s.createOIDCUser(ctx, providerName, claims, saved.Role, "")
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{User: user}, nil
+	}
+
+	signupToken, err := s.keys.GenerateTypedToken(utils.TokenTypeOIDCSignup, jwt.MapClaims{
+		"provider":       providerName,
+		"sub":            claims.Subject,
+		"email":          claims.Email,
+		"email_verified": claims.EmailVerified,
+		"name":           claims.Name,
+	}, OIDCSignupTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCResult{SignupToken: signupToken}, nil
+}
+
+// To create the account of a first-time social sign-in once a role is chosen
+func (s *OIDCService) CompleteOIDCSignup(ctx context.Context, signupToken, role, number string) (*models.User, error) {
+	if !isSelfServiceRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	token, err := s.keys.VerifyTypedToken(signupToken, utils.TokenTypeOIDCSignup)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+
+	provider, _ := token["provider"].(string)
+	claims := &oidc.Claims{}
+	claims.Subject, _ = token["sub"].(string)
+	claims.Email, _ = token["email"].(string)
+	claims.EmailVerified, _ = token["email_verified"].(bool)
+	claims.Name, _ = token["name"].(string)
+
+	// To handle a second completion of the same signup, or an account created meanwhile
+	user, err := s.resolveIdentity(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	return s.createOIDCUser(ctx, provider, claims, role, number)
+}
+
+// To find the user for an external identity, linking it to an existing
+// account when the provider vouches for an email that account has verified.
+// It returns nil when a new account has to be created.
+func (s *OIDCService) resolveIdentity(ctx context.Context, provider string, claims *oidc.Claims) (*models.User, error) {
+	var identity models.UserIdentity
+	err := s.db.WithContext(ctx).Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+	if err == nil {
+		var user models.User
+		if err := s.db.WithContext(ctx).First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, nil
+	}
+
+	var user models.User
+	err = s.db.WithContext(ctx).Where("email = ?", utils.NormalizeEmail(claims.Email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.EmailVerified || !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	if err := s.db.WithContext(ctx).Create(&models.UserIdentity{
+		UserID:    user.ID,
+		Provider:  provider,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *OIDCService) createOIDCUser(ctx context.Context, provider string, claims *oidc.Claims, role, number string) (*models.User, error) {
+	if claims.Email == "" {
+		return nil, ErrEmailRequired
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	user := models.User{
+		Name:   name,
+		Email:  utils.NormalizeEmail(claims.Email),
+		Number: number,
+		Role:   role,
+		// No password, the user can set one later through forgot-password
+		Password:      "",
+		EmailVerified: claims.EmailVerified,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:    user.ID,
+			Provider:  provider,
+			Subject:   claims.Subject,
+			Email:     claims.Email,
+			CreatedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// To check a role users may pick for themselves
+func isSelfServiceRole(role string) bool {
+	return role == models.RoleStylist || role == models.RoleCustomer
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"ezwait/internal/models"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CodeTTL            = 15 * time.Minute
+	CodeResendInterval = time.Minute
+	MaxCodesPerHour    = 5
+	MaxCodeAttempts    = 5
+)
+
+var (
+	ErrInvalidCode     = errors.New("invalid or expired code")
+	ErrTooManyAttempts = errors.New("too many incorrect attempts, request a new code")
+)
+
+// ResendError is returned when a new code is requested too soon
+type ResendError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ResendError) Error() string {
+	return fmt.Sprintf("please wait %d seconds before requesting a new code", int(e.RetryAfter.Seconds())+1)
+}
+
+// CodeService issues the short numeric codes sent by email or SMS and checks them
+type CodeService struct {
+	db *gorm.DB
+}
+
+// To build a CodeService on db
+func NewCodeService(db *gorm.DB) *CodeService {
+	return &CodeService{db: db}
+}
+
+// To issue a fresh numeric code for a purpose, replacing any outstanding one
+func (s *CodeService) IssueCode(ctx context.Context, userID uint, purpose string) (string, error) {
+	if err := s.checkResendThrottle(ctx, userID, purpose); err != nil {
+		return "", err
+	}
+
+	code, err := randomDigits(6)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// To expire previously issued codes so only the latest one works
+		if err := tx.Model(&models.OneTimeCode{}).
+			Where("user_id = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", userID, purpose, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.OneTimeCode{
+			UserID:    userID,
+			Purpose:   purpose,
+			CodeHash:  string(hash),
+			ExpiresAt: now.Add(CodeTTL),
+			CreatedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// To check a code and consume it on success
+func (s *CodeService) VerifyCode(ctx context.Context, userID uint, purpose, code string) error {
+	var otp models.OneTimeCode
+	err := s.db.WithContext(ctx).Where("user_id = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", userID, purpose, time.Now()).
+		Order("created_at DESC").
+		First(&otp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	// To use up an attempt before comparing, in one statement, so concurrent
+	// guesses can't get past MaxCodeAttempts between a read and a write
+	result := s.db.WithContext(ctx).Model(&otp).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "attempts"}}}).
+		Where("attempts < ?", MaxCodeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTooManyAttempts
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(otp.CodeHash), []byte(code)) != nil {
+		return ErrInvalidCode
+	}
+
+	// To consume the code, a concurrent request that got here first wins
+	result = s.db.WithContext(ctx).Model(&models.OneTimeCode{}).
+		Where("id = ? AND consumed_at IS NULL", otp.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (s *CodeService) checkResendThrottle(ctx context.Context, userID uint, purpose string) error {
+	var recent []models.OneTimeCode
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, time.Now().Add(-time.Hour)).
+		Order("created_at DESC").
+		Find(&recent).Error; err != nil {
+		return err
+	}
+
+	if len(recent) == 0 {
+		return nil
+	}
+
+	if wait := CodeResendInterval - time.Since(recent[0].CreatedAt); wait > 0 {
+		return &ResendError{RetryAfter: wait}
+	}
+
+	if len(recent) >= MaxCodesPerHour {
+		oldest := recent[len(recent)-1]
+		return &ResendError{RetryAfter: time.Hour - time.Since(oldest.CreatedAt)}
+	}
+
+	return nil
+}
+
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", n, v), nil
+}
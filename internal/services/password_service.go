@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/mailer"
+	"ezwait/internal/models"
+	"ezwait/internal/passwords"
+	"ezwait/internal/utils"
+	"fmt"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PasswordResetTTL      = 30 * time.Minute
+	PasswordResetInterval = time.Minute
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordService checks and hashes passwords with the configured policy and
+// hasher, and resets forgotten ones with an emailed token that links to
+// accounts.PasswordResetURL when it is set
+type PasswordService struct {
+	db       *gorm.DB
+	policy   passwords.Policy
+	hasher   *passwords.Hasher
+	accounts config.AccountsConfig
+	mailer   mailer.Mailer
+	sessions *SessionService
+}
+
+// To build a PasswordService, sessions signs users out after a reset
+func NewPasswordService(db *gorm.DB, policy passwords.Policy, hasher *passwords.Hasher, accounts config.AccountsConfig, mailer mailer.Mailer, sessions *SessionService) *PasswordService {
+	return &PasswordService{db: db, policy: policy, hasher: hasher, accounts: accounts, mailer: mailer, sessions: sessions}
+}
+
+// To check a new password against the policy, personal holds the user's
+// name and email, which the password must not contain
+func (s *PasswordService) Check(password string, personal ...string) error {
+	return s.policy.Check(password, personal...)
+}
+
+// To hash a password with the configured algorithm
+func (s *PasswordService) Hash(password string) (string, error) {
+	return s.hasher.Hash(password)
+}
+
+// To check a password against a stored hash of either algorithm
+func (s *PasswordService) Verify(hash, password string) (bool, error) {
+	return s.hasher.Verify(hash, password)
+}
+
+// To email a password reset token if the email belongs to an account.
+// Unknown emails are silently ignored so callers can't probe for accounts.
+func (s *PasswordService) RequestPasswordReset(ctx context.Context, email string) error {
+	var user models.User
+	err := s.db.WithContext(ctx).Where("email = ?", utils.NormalizeEmail(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// To quietly drop repeat requests made in quick succession
+	var latest models.PasswordResetToken
+	if err := s.db.WithContext(ctx).Where("user_id = ?", user.ID).Order("created_at DESC").First(&latest).Error; err == nil {
+		if time.Since(latest.CreatedAt) < PasswordResetInterval {
+			return nil
+		}
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// To keep only the newest reset token usable
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ?", user.ID, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: now.Add(PasswordResetTTL),
+			CreatedAt: now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your EzWait password",
+		Body:    s.resetEmailBody(user.Name, token),
+	})
+}
+
+// To set a new password using a reset token and end every existing session
+func (s *PasswordService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	var reset models.PasswordResetToken
+	err := s.db.WithContext(ctx).Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&reset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, reset.UserID).Error; err != nil {
+		return err
+	}
+
+	// To check the policy before the token is used up, so the user can try another password
+	if err := s.policy.Check(newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// To consume the token, a concurrent reset that got here first wins
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", hashedPassword).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeUserTokens(ctx, reset.UserID)
+}
+
+// To replace a user's password hash after a successful login when it was made
+// with another algorithm or cost than the configured one
+func (s *PasswordService) UpgradePasswordHash(ctx context.Context, user *models.User, password string) error {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return nil
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// To leave the row alone if the password changed since it was loaded
+	err = s.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashedPassword).Error
+	if err != nil {
+		return err
+	}
+
+	user.Password = hashedPassword
+	return nil
+}
+
+func (s *PasswordService) resetEmailBody(name, token string) string {
+	body := fmt.Sprintf("Hi %s,\n\nWe received a request to reset your EzWait password.\n\n", name)
+
+	// To include a deep link when the app has one configured
+	if base := s.accounts.PasswordResetURL; base != "" {
+		body += fmt.Sprintf("Open this link to choose a new password:\n%s?token=%s\n\n", base, url.QueryEscape(token))
+	}
+
+	body += fmt.Sprintf("Your reset token is:\n%s\n\nIt expires in %d minutes and can only be used once. If you did not ask for this, you can ignore this email.\n",
+		token, int(PasswordResetTTL.Minutes()))
+
+	return body
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/internal/sms"
+	"ezwait/internal/utils"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var ErrPhoneTaken = errors.New("this number is verified by another account")
+
+const (
+	// SMS sends allowed per IP within SMSSendWindow, on top of the per-user code throttle
+	SMSSendsPerIP = 10
+	SMSSendWindow = time.Hour
+)
+
+// PhoneService links verified numbers to accounts and logs users in with a
+// code texted to theirs. Numbers in national format get
+// accounts.DefaultCountryCode.
+type PhoneService struct {
+	db       *gorm.DB
+	accounts config.AccountsConfig
+	sms      sms.SMSSender
+	codes    *CodeService
+	throttle *ThrottleService
+}
+
+// To build a PhoneService, texting codes from codes through sms and rate
+// limiting the texts per IP with throttle
+func NewPhoneService(db *gorm.DB, accounts config.AccountsConfig, sms sms.SMSSender, codes *CodeService, throttle *ThrottleService) *PhoneService {
+	return &PhoneService{db: db, accounts: accounts, sms: sms, codes: codes, throttle: throttle}
+}
+
+// To normalize a number using the default country code for national formats
+func (s *PhoneService) NormalizePhone(raw string) (string, error) {
+	return utils.NormalizePhone(raw, s.accounts.DefaultCountryCode)
+}
+
+// To find the user who verified a phone number. Numbers typed in at
+// registration or on the profile are never trusted, their owner has to verify
+// them with RequestPhoneVerification first.
+func (s *PhoneService) FindUserByPhone(ctx context.Context, e164 string) (*models.User, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("phone_e164 = ? AND phone_verified_at IS NOT NULL", e164).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// To text a login code to the number if it belongs to an account. Unknown
+// numbers are silently ignored so callers can't probe for accounts.
+func (s *PhoneService) RequestPhoneLogin(ctx context.Context, raw, ip string) error {
+	e164, err := s.NormalizePhone(raw)
+	if err != nil {
+		return err
+	}
+
+	wait, err := s.throttle.CheckRateLimit(ctx, "sms_send", ip, SMSSendsPerIP, SMSSendWindow)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &ResendError{RetryAfter: wait}
+	}
+
+	if err := s.throttle.RecordAttempt(ctx, "sms_send", e164, ip, true); err != nil {
+		return err
+	}
+
+	user, err := s.FindUserByPhone(ctx, e164)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// To hide the per-user resend throttle, it would reveal that the number exists
+	code, err := s.codes.IssueCode(ctx, user.ID, models.PurposePhoneLogin)
+	var resendErr *ResendError
+	if errors.As(err, &resendErr) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.sms.Send(e164, fmt.Sprintf("Your EzWait login code is %s. It expires in %d minutes.", code, int(CodeTTL.Minutes())))
+}
+
+// To check a phone login code for the account that verified the number
+func (s *PhoneService) VerifyPhoneLogin(ctx context.Context, raw, code string) (*models.User, error) {
+	e164, err := s.NormalizePhone(raw)
+	if err != nil {
+		return nil, ErrInvalidCode
+	}
+
+	user, err := s.FindUserByPhone(ctx, e164)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.codes.VerifyCode(ctx, user.ID, models.PurposePhoneLogin, code); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// To text a code proving a signed-in user owns a number, which phone login
+// then accepts. A number verified by another account can't be claimed.
+func (s *PhoneService) RequestPhoneVerification(ctx context.Context, user *models.User, raw, ip string) error {
+	e164, err := s.NormalizePhone(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPhoneFree(ctx, user.ID, e164); err != nil {
+		return err
+	}
+
+	wait, err := s.throttle.CheckRateLimit(ctx, "sms_send", ip, SMSSendsPerIP, SMSSendWindow)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &ResendError{RetryAfter: wait}
+	}
+
+	if err := s.throttle.RecordAttempt(ctx, "sms_send", e164, ip, true); err != nil {
+		return err
+	}
+
+	code, err := s.codes.IssueCode(ctx, user.ID, phoneVerificationPurpose(e164))
+	if err != nil {
+		return err
+	}
+
+	return s.sms.Send(e164, fmt.Sprintf("Your EzWait verification code is %s. It expires in %d minutes.", code, int(CodeTTL.Minutes())))
+}
+
+// To link a number to the user once they enter the code texted to it
+func (s *PhoneService) ConfirmPhone(ctx context.Context, user *models.User, raw, code string) error {
+	e164, err := s.NormalizePhone(raw)
+	if err != nil {
+		return ErrInvalidCode
+	}
+
+	if err := s.checkPhoneFree(ctx, user.ID, e164); err != nil {
+		return err
+	}
+
+	// To only accept a code sent to this very number
+	if err := s.codes.VerifyCode(ctx, user.ID, phoneVerificationPurpose(e164), code); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"number":            e164,
+		"phone_e164":        e164,
+		"phone_verified_at": now,
+	}).Error; err != nil {
+		if isUniqueViolation(err) {
+			return ErrPhoneTaken
+		}
+		return err
+	}
+	user.Number = e164
+	user.PhoneE164 = &e164
+	user.PhoneVerifiedAt = &now
+
+	return nil
+}
+
+// To refuse a number another account has verified
+func (s *PhoneService) checkPhoneFree(ctx context.Context, userID uint, e164 string) error {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.User{}).Where("phone_e164 = ? AND id <> ?", e164, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPhoneTaken
+	}
+	return nil
+}
+
+// To tell whether err is Postgres refusing a duplicate in a unique column,
+// e.g. two accounts confirming the same number at once
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// To tie a verification code to the number it was sent to
+func phoneVerificationPurpose(e164 string) string {
+	return models.PurposePhoneVerification + ":" + e164
+}
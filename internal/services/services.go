@@ -0,0 +1,79 @@
+package services
+
+import (
+	"ezwait/config"
+	"ezwait/internal/mailer"
+	"ezwait/internal/oidc"
+	"ezwait/internal/passwords"
+	"ezwait/internal/repository"
+	"ezwait/internal/sms"
+	"ezwait/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// Services are the domain services, each built with the repositories,
+// settings and other services it uses
+type Services struct {
+	Bookings      *BookingService
+	Stylists      *StylistService
+	Users         *UserService
+	Verification  *VerificationService
+	Sessions      *SessionService
+	Codes         *CodeService
+	Throttle      *ThrottleService
+	TwoFactor     *TwoFactorService
+	Passwords     *PasswordService
+	Phones        *PhoneService
+	Accounts      *AccountService
+	Notifications *NotificationService
+	Exports       *ExportService
+	OIDC          *OIDCService
+	Cleanup       *CleanupService
+
+	// Keys sign and verify every token the services issue
+	Keys *utils.KeyRing
+}
+
+// Deps are what the services need besides the database: the config sections
+// they read, the token signing keys and sign-in providers, and the password,
+// mail and SMS implementations built from their settings
+type Deps struct {
+	Accounts  config.AccountsConfig
+	Exports   config.ExportsConfig
+	JWT       config.JWTConfig
+	Keys      *utils.KeyRing
+	Providers *oidc.Providers
+	Policy    passwords.Policy
+	Hasher    *passwords.Hasher
+	Mailer    mailer.Mailer
+	SMS       sms.SMSSender
+}
+
+// To build the services on the Gorm repositories for db
+func New(db *gorm.DB, deps Deps) *Services {
+	users := repository.NewUserRepository(db)
+	stylists := repository.NewStylistRepository(db)
+	bookings := repository.NewBookingRepository(db)
+
+	s := &Services{
+		Bookings: NewBookingService(bookings, stylists),
+		Stylists: NewStylistService(stylists),
+		Sessions: NewSessionService(db, deps.Keys, deps.JWT),
+		Codes:    NewCodeService(db),
+		OIDC:     NewOIDCService(db, deps.Keys, deps.Providers),
+		Cleanup:  NewCleanupService(db),
+		Keys:     deps.Keys,
+	}
+	s.Verification = NewVerificationService(db, s.Codes, deps.Mailer)
+	s.Users = NewUserService(users, deps.Accounts.DefaultCountryCode, s.Verification.SendEmailVerification, s.Sessions.RevokeUserTokens)
+	s.Throttle = NewThrottleService(db, s.Codes, deps.Mailer)
+	s.TwoFactor = NewTwoFactorService(db, deps.Accounts)
+	s.Passwords = NewPasswordService(db, deps.Policy, deps.Hasher, deps.Accounts, deps.Mailer, s.Sessions)
+	s.Phones = NewPhoneService(db, deps.Accounts, deps.SMS, s.Codes, s.Throttle)
+	s.Notifications = NewNotificationService(db, deps.Mailer)
+	s.Accounts = NewAccountService(db, deps.Accounts, s.Sessions, s.Notifications)
+	s.Exports = NewExportService(db, deps.Keys, deps.Exports, s.Notifications)
+
+	return s
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/models"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// How often a session's last-seen time is written, so every request doesn't cost an UPDATE
+const SessionTouchInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionDevice describes the device a session is started from
+type SessionDevice struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// To list a user's active sessions, most recently used first
+func (s *SessionService) ListSessions(ctx context.Context, userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.WithContext(ctx).Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+// To sign a user out of one of their sessions
+func (s *SessionService) RevokeSession(ctx context.Context, userID uint, sessionID string) error {
+	var session models.Session
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.RevokeFamily(ctx, session.ID)
+}
+
+// To check that a session still exists, refreshing its last-seen time and IP
+// at most once per SessionTouchInterval
+func (s *SessionService) TouchSession(ctx context.Context, sessionID, ip string) (bool, error) {
+	var session models.Session
+	err := s.db.WithContext(ctx).Select("id", "last_seen_at").Where("id = ?", sessionID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if time.Since(session.LastSeenAt) > SessionTouchInterval {
+		if err := s.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+			"last_seen_at": time.Now(),
+			"ip":           ip,
+		}).Error; err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// To cut s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
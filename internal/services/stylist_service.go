@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/models"
+	"ezwait/internal/repository"
+	"time"
+)
+
+var ErrStylistProfileExists = errors.New("stylist profile already exists")
+
+// StylistService manages stylist profiles, which are keyed by the stylist's
+// user ID
+type StylistService struct {
+	stylists repository.StylistRepository
+	now      func() time.Time
+}
+
+// To build a StylistService on the given repository
+func NewStylistService(stylists repository.StylistRepository) *StylistService {
+	return &StylistService{stylists: stylists, now: time.Now}
+}
+
+// To create the profile of stylist user userID from profile's services,
+// samples, time slots and picture. A new profile is active with no ratings
+// or bookings yet.
+func (s *StylistService) CreateProfile(ctx context.Context, userID uint, profile models.Stylist) (*models.Stylist, error) {
+	_, err := s.stylists.FindByUserID(ctx, userID)
+	if err == nil {
+		return nil, ErrStylistProfileExists
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	stylist := &models.Stylist{
+		StylistID:          userID,
+		ActiveStatus:       true,
+		ProfilePicture:     profile.ProfilePicture,
+		Services:           profile.Services,
+		SampleOfServices:   profile.SampleOfServices,
+		AvailableTimeSlots: profile.AvailableTimeSlots,
+		CreatedAt:          s.now(),
+	}
+	if err := s.stylists.Create(ctx, stylist); err != nil {
+		return nil, err
+	}
+	return stylist, nil
+}
+
+// To fetch the profile of stylist user userID with its user
+func (s *StylistService) Get(ctx context.Context, userID uint) (*models.Stylist, error) {
+	stylist, err := s.stylists.FindWithUser(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrStylistNotFound
+	}
+	return stylist, err
+}
+
+// To list the stylists customers can book
+func (s *StylistService) List(ctx context.Context, filter repository.StylistFilter, page repository.Page) ([]models.Stylist, error) {
+	return s.stylists.List(ctx, filter, page)
+}
+
+// To apply change to the profile of stylist user userID and save it, the
+// profile comes back without its user
+func (s *StylistService) Update(ctx context.Context, userID uint, change func(stylist *models.Stylist)) (*models.Stylist, error) {
+	stylist, err := s.stylists.FindByUserID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrStylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change(stylist)
+
+	if err := s.stylists.Save(ctx, stylist); err != nil {
+		return nil, err
+	}
+	return stylist, nil
+}
+
+// To hide or re-list a stylist
+func (s *StylistService) SetActive(ctx context.Context, userID uint, active bool) (*models.Stylist, error) {
+	return s.Update(ctx, userID, func(stylist *models.Stylist) {
+		stylist.ActiveStatus = active
+	})
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/mailer"
+	"ezwait/internal/models"
+	"ezwait/internal/utils"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ThrottlePolicy decides how long a key must wait after repeated failures
+type ThrottlePolicy struct {
+	// Failures allowed before any delay kicks in
+	FreeAttempts int
+	// Delay after the first counted failure, doubled on each further one
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures after which the key is locked for LockoutDuration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Failures older than this are forgotten
+	Window time.Duration
+}
+
+var (
+	// Per account, strict because it protects one password
+	AccountThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 30 * time.Minute,
+		Window:          24 * time.Hour,
+	}
+
+	// Per IP, lenient because many customers can share one mobile carrier NAT
+	IPThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+)
+
+// To compute the wait imposed after the given number of consecutive failures
+func (p ThrottlePolicy) Delay(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(failures-p.FreeAttempts-1)))
+	if delay > p.MaxDelay || delay <= 0 {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+// ThrottleService slows down and locks out repeated failed attempts, per
+// account and per IP, and unlocks accounts with an emailed code
+type ThrottleService struct {
+	db     *gorm.DB
+	codes  *CodeService
+	mailer mailer.Mailer
+}
+
+// To build a ThrottleService, codes and mailer send unlock codes
+func NewThrottleService(db *gorm.DB, codes *CodeService, mailer mailer.Mailer) *ThrottleService {
+	return &ThrottleService{db: db, codes: codes, mailer: mailer}
+}
+
+func accountKey(scope, identifier string) string {
+	return scope + ":account:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func ipKey(scope, ip string) string {
+	return scope + ":ip:" + ip
+}
+
+// throttleKey is a throttle row and the policy it is counted under
+type throttleKey struct {
+	name   string
+	policy ThrottlePolicy
+}
+
+// To list the keys an attempt counts against, sorted so transactions locking
+// several of them always lock in the same order
+func throttleKeys(scope, identifier, ip string) []throttleKey {
+	keys := []throttleKey{{ipKey(scope, ip), IPThrottlePolicy}}
+	if identifier != "" {
+		keys = append(keys, throttleKey{accountKey(scope, identifier), AccountThrottlePolicy})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	return keys
+}
+
+// To reserve an attempt for the account and IP before the credentials are
+// checked. The attempt counts as a failure right away, so a burst of parallel
+// guesses can't all pass the check before any of them is counted. When the
+// account or IP is locked nothing is counted and the wait is returned. The
+// caller then settles the attempt with CompleteAttempt or ReleaseAttempt.
+func (s *ThrottleService) ReserveAttempt(ctx context.Context, scope, identifier, ip string) (time.Duration, error) {
+	var wait time.Duration
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		keys := throttleKeys(scope, identifier, ip)
+		throttles, err := lockThrottles(tx, keys)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, t := range throttles {
+			if t.LockedUntil != nil && t.LockedUntil.Sub(now) > wait {
+				wait = t.LockedUntil.Sub(now)
+			}
+		}
+		if wait > 0 {
+			return nil
+		}
+
+		for _, key := range keys {
+			throttle := throttles[key.name]
+			if throttle.LastFailureAt != nil && now.Sub(*throttle.LastFailureAt) > key.policy.Window {
+				throttle.Failures = 0
+			}
+			throttle.Failures++
+			throttle.LastFailureAt = &now
+			throttle.UpdatedAt = now
+			throttle.LockedUntil = nil
+			if delay := key.policy.Delay(throttle.Failures); delay > 0 {
+				lockedUntil := now.Add(delay)
+				throttle.LockedUntil = &lockedUntil
+			}
+			if err := tx.Save(throttle).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wait, err
+}
+
+// To settle a reserved attempt once the credentials were checked: a failure
+// stays counted, a success forgives the account and gives the IP its attempt
+// back. Either way the attempt is audited.
+func (s *ThrottleService) CompleteAttempt(ctx context.Context, scope, identifier, ip string, success bool) error {
+	if err := s.RecordAttempt(ctx, scope, identifier, ip, success); err != nil {
+		return err
+	}
+	if !success {
+		return nil
+	}
+
+	if err := s.release(ctx, []throttleKey{{ipKey(scope, ip), IPThrottlePolicy}}); err != nil {
+		return err
+	}
+	if identifier == "" {
+		return nil
+	}
+	return s.ClearThrottle(ctx, scope, identifier)
+}
+
+// To give back a reserved attempt that never got to check credentials, e.g.
+// a request refused for a malformed body
+func (s *ThrottleService) ReleaseAttempt(ctx context.Context, scope, identifier, ip string) error {
+	return s.release(ctx, throttleKeys(scope, identifier, ip))
+}
+
+// To audit an attempt, counting is left to ReserveAttempt
+func (s *ThrottleService) RecordAttempt(ctx context.Context, scope, identifier, ip string, success bool) error {
+	return s.db.WithContext(ctx).Create(&models.LoginAttempt{
+		Scope:      scope,
+		Identifier: strings.ToLower(strings.TrimSpace(identifier)),
+		IP:         ip,
+		Success:    success,
+		CreatedAt:  time.Now(),
+	}).Error
+}
+
+// To check a plain rate limit on an action audited under scope, returning how
+// long the IP must wait once it has used up limit actions within window
+func (s *ThrottleService) CheckRateLimit(ctx context.Context, scope, ip string, limit int, window time.Duration) (time.Duration, error) {
+	since := time.Now().Add(-window)
+
+	var attempts []models.LoginAttempt
+	if err := s.db.WithContext(ctx).Where("scope = ? AND ip = ? AND created_at > ?", scope, ip, since).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&attempts).Error; err != nil {
+		return 0, err
+	}
+
+	if len(attempts) < limit {
+		return 0, nil
+	}
+
+	return attempts[0].CreatedAt.Add(window).Sub(time.Now()), nil
+}
+
+// To unlock an account within a scope
+func (s *ThrottleService) ClearThrottle(ctx context.Context, scope, identifier string) error {
+	return s.db.WithContext(ctx).Where("key = ?", accountKey(scope, identifier)).Delete(&models.AuthThrottle{}).Error
+}
+
+// To take back one counted failure on each key, the lock then follows from
+// the failures left
+func (s *ThrottleService) release(ctx context.Context, keys []throttleKey) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		throttles, err := lockThrottles(tx, keys)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			throttle := throttles[key.name]
+			if throttle.Failures == 0 {
+				continue
+			}
+			throttle.Failures--
+			throttle.UpdatedAt = time.Now()
+			throttle.LockedUntil = nil
+			if delay := key.policy.Delay(throttle.Failures); delay > 0 && throttle.LastFailureAt != nil {
+				lockedUntil := throttle.LastFailureAt.Add(delay)
+				throttle.LockedUntil = &lockedUntil
+			}
+			if err := tx.Save(throttle).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// To lock the throttle rows of keys for the rest of tx, creating missing ones
+func lockThrottles(tx *gorm.DB, keys []throttleKey) (map[string]*models.AuthThrottle, error) {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.AuthThrottle{Key: key.name, UpdatedAt: time.Now()}).Error; err != nil {
+			return nil, err
+		}
+		names = append(names, key.name)
+	}
+
+	var rows []models.AuthThrottle
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", names).Order("key").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	throttles := make(map[string]*models.AuthThrottle, len(rows))
+	for i := range rows {
+		throttles[rows[i].Key] = &rows[i]
+	}
+	if len(throttles) != len(keys) {
+		return nil, fmt.Errorf("throttle rows missing for %v", names)
+	}
+	return throttles, nil
+}
+
+// To email an unlock code to an account that is locked out of login. Unknown
+// or unlocked emails are silently ignored so callers can't probe for accounts.
+func (s *ThrottleService) RequestAccountUnlock(ctx context.Context, email string) error {
+	var throttle models.AuthThrottle
+	err := s.db.WithContext(ctx).Where("key = ? AND locked_until > ?", accountKey("login", email), time.Now()).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = s.db.WithContext(ctx).Where("email = ?", utils.NormalizeEmail(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err := s.codes.IssueCode(ctx, user.ID, models.PurposeAccountUnlock)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Unlock your EzWait account",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account was locked after several failed sign-in attempts. Use code %s to unlock it. It expires in %d minutes.\n\nIf these attempts were not you, consider resetting your password.\n",
+			user.Name, code, int(CodeTTL.Minutes())),
+	})
+}
+
+// To unlock login for an account using an emailed code
+func (s *ThrottleService) UnlockAccount(ctx context.Context, email, code string) error {
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("email = ?", utils.NormalizeEmail(email)).First(&user).Error; err != nil {
+		return ErrInvalidCode
+	}
+
+	if err := s.codes.VerifyCode(ctx, user.ID, models.PurposeAccountUnlock, code); err != nil {
+		return err
+	}
+
+	return s.ClearThrottle(ctx, "login", email)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/config"
+	"ezwait/internal/models"
+	"ezwait/internal/utils"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotStarted     = errors.New("start two-factor setup first")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for your role")
+	ErrInvalidTwoFactorCode    = errors.New("invalid authentication code")
+)
+
+// TwoFactorService manages TOTP authenticators, recovery codes and the
+// per-role 2FA policy. accounts names the TOTP issuer and the roles that
+// require 2FA when no admin has set a policy.
+type TwoFactorService struct {
+	db       *gorm.DB
+	accounts config.AccountsConfig
+}
+
+// To build a TwoFactorService with the account settings
+func NewTwoFactorService(db *gorm.DB, accounts config.AccountsConfig) *TwoFactorService {
+	return &TwoFactorService{db: db, accounts: accounts}
+}
+
+// To generate and store a new TOTP secret, 2FA stays off until the first code is confirmed
+func (s *TwoFactorService) BeginTwoFactorSetup(ctx context.Context, user *models.User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", err
+	}
+	user.TOTPSecret = secret
+
+	return secret, utils.TOTPURI(s.accounts.TOTPIssuer, user.Email, secret), nil
+}
+
+// To turn 2FA on once the user proves their app produces valid codes. The
+// recovery codes are returned in plain text only this once.
+func (s *TwoFactorService) ConfirmTwoFactor(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotStarted
+	}
+
+	if err := s.consumeTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"totp_enabled":    true,
+		"totp_enabled_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	user.TOTPEnabledAt = &now
+
+	return s.RegenerateRecoveryCodes(ctx, user)
+}
+
+// To check a TOTP code or, failing that, a recovery code for a user with 2FA on
+func (s *TwoFactorService) VerifyTwoFactor(ctx context.Context, user *models.User, code, recoveryCode string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if recoveryCode != "" {
+		return s.useRecoveryCode(ctx, user, recoveryCode)
+	}
+
+	return s.consumeTOTP(ctx, user, code)
+}
+
+// To turn 2FA off, refused while the user's role requires it
+func (s *TwoFactorService) DisableTwoFactor(ctx context.Context, user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	required, err := s.TwoFactorRequired(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequired
+	}
+
+	if err := s.consumeTOTP(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled":    false,
+			"totp_enabled_at": nil,
+			"totp_secret":     "",
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// To replace all recovery codes of a user with a fresh set
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, user *models.User) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, RecoveryCodeCount)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			UserID:    user.ID,
+			CodeHash:  string(hash),
+			CreatedAt: time.Now(),
+		})
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// To check whether users of a role must use 2FA. An admin-set policy wins,
+// otherwise TWO_FACTOR_REQUIRED_ROLES (comma separated) is the default.
+func (s *TwoFactorService) TwoFactorRequired(ctx context.Context, role string) (bool, error) {
+	var policy models.AuthPolicy
+	err := s.db.WithContext(ctx).Where("role = ?", role).First(&policy).Error
+	if err == nil {
+		return policy.RequireTwoFactor, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+
+	for _, r := range s.accounts.TwoFactorRequiredRoles {
+		if r == role {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// To force (or stop forcing) 2FA for every user of a role
+func (s *TwoFactorService) SetTwoFactorPolicy(ctx context.Context, role string, required bool) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "role"}},
+		DoUpdates: clause.AssignmentColumns([]string{"require_two_factor", "updated_at"}),
+	}).Create(&models.AuthPolicy{
+		Role:             role,
+		RequireTwoFactor: required,
+		UpdatedAt:        time.Now(),
+	}).Error
+}
+
+// To validate a TOTP code and record its time step so it can't be replayed
+func (s *TwoFactorService) consumeTOTP(ctx context.Context, user *models.User, code string) error {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+
+	return nil
+}
+
+func (s *TwoFactorService) useRecoveryCode(ctx context.Context, user *models.User, code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	var codes []models.RecoveryCode
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND used_at IS NULL", user.ID).Find(&codes).Error; err != nil {
+		return err
+	}
+
+	for _, rc := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(rc.CodeHash), []byte(code)) != nil {
+			continue
+		}
+
+		result := s.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", rc.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+func randomRecoveryCode() (string, error) {
+	token, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(token[:10])
+	return code[:5] + "-" + code[5:], nil
+}
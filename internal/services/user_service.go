@@ -1 +1,248 @@
 package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/mailer"
+	"ezwait/internal/models"
+	"ezwait/internal/rbac"
+	"ezwait/internal/repository"
+	"ezwait/internal/utils"
+	"ezwait/pkg/logger"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAlreadyVerified = errors.New("email is already verified")
+	ErrUnknownRole     = errors.New("unknown role")
+)
+
+// VerificationService proves users own their email address with an emailed code
+type VerificationService struct {
+	db     *gorm.DB
+	codes  *CodeService
+	mailer mailer.Mailer
+}
+
+// To build a VerificationService sending codes from codes through mailer
+func NewVerificationService(db *gorm.DB, codes *CodeService, mailer mailer.Mailer) *VerificationService {
+	return &VerificationService{db: db, codes: codes, mailer: mailer}
+}
+
+// To email a verification code to the user
+func (s *VerificationService) SendEmailVerification(ctx context.Context, user *models.User) error {
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	code, err := s.codes.IssueCode(ctx, user.ID, models.PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your EzWait email",
+		Body: fmt.Sprintf("Hi %s,\n\nYour EzWait verification code is %s. It expires in %d minutes.\n\nIf you did not create an EzWait account you can ignore this email.\n",
+			user.Name, code, int(CodeTTL.Minutes())),
+	})
+}
+
+// To mark the user's email as verified if the code matches
+func (s *VerificationService) VerifyEmail(ctx context.Context, user *models.User, code string) error {
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	if err := s.codes.VerifyCode(ctx, user.ID, models.PurposeEmailVerification, code); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+
+	return s.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"email_verified":    true,
+		"email_verified_at": now,
+	}).Error
+}
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email is already in use")
+)
+
+// ProfileUpdate is what a user may change on their own account
+type ProfileUpdate struct {
+	Name           string
+	Email          string
+	Number         string
+	Location       string
+	ProfilePicture string
+}
+
+// UserService manages user accounts. Numbers in national format get
+// countryCode, sendVerification emails a code to a new or changed address,
+// VerificationService.SendEmailVerification in production, and revokeTokens
+// signs a user out everywhere, SessionService.RevokeUserTokens in production.
+type UserService struct {
+	users            repository.UserRepository
+	countryCode      string
+	sendVerification func(ctx context.Context, user *models.User) error
+	revokeTokens     func(ctx context.Context, userID uint) error
+}
+
+// To build a UserService on the given repository
+func NewUserService(users repository.UserRepository, countryCode string, sendVerification func(ctx context.Context, user *models.User) error, revokeTokens func(ctx context.Context, userID uint) error) *UserService {
+	return &UserService{users: users, countryCode: countryCode, sendVerification: sendVerification, revokeTokens: revokeTokens}
+}
+
+// To load a user by ID
+func (s *UserService) Get(ctx context.Context, userID uint) (*models.User, error) {
+	user, err := s.users.FindByID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// To load a user by email
+func (s *UserService) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.users.FindByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// To create the account and email it a verification code. The user can ask
+// for a new code if sending this one fails, so that only gets logged.
+func (s *UserService) Register(ctx context.Context, user *models.User) error {
+	user.Email = utils.NormalizeEmail(user.Email)
+	taken, err := s.users.EmailTaken(ctx, user.Email, 0)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	if err := s.users.Create(ctx, user); err != nil {
+		// To cover a registration with the same email that got in first
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+
+	if err := s.sendVerification(ctx, user); err != nil {
+		logger.Error(ctx, "Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+
+	return nil
+}
+
+// To store a new password hash and end all sessions, so the old password can
+// no longer be used anywhere
+func (s *UserService) SetPassword(ctx context.Context, user *models.User, hash string) error {
+	if err := s.users.Update(ctx, user, map[string]interface{}{"password": hash}); err != nil {
+		return err
+	}
+
+	return s.revokeTokens(ctx, user.ID)
+}
+
+// To change a user's role and sign them out, since the role is carried in their access tokens
+func (s *UserService) ChangeRole(ctx context.Context, userID uint, role string) (*models.User, error) {
+	if !rbac.IsRole(role) {
+		return nil, ErrUnknownRole
+	}
+
+	user, err := s.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role == role {
+		return user, nil
+	}
+
+	if err := s.users.Update(ctx, user, map[string]interface{}{"role": role}); err != nil {
+		return nil, err
+	}
+
+	if err := s.revokeTokens(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// To update a user's own profile. A new email must not belong to another
+// account and has to be verified again, a new number unlinks the verified phone.
+func (s *UserService) UpdateProfile(ctx context.Context, userID uint, update ProfileUpdate) (*models.User, error) {
+	user, err := s.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// To write only what the update changes, so a concurrent change to
+	// another column, such as the role or password, is not overwritten
+	fields := map[string]interface{}{}
+	set := func(column, current, value string) {
+		if value != current {
+			fields[column] = value
+		}
+	}
+	set("name", user.Name, update.Name)
+	set("email", user.Email, utils.NormalizeEmail(update.Email))
+	set("number", user.Number, update.Number)
+	set("location", user.Location, update.Location)
+	set("profile_picture", user.ProfilePicture, update.ProfilePicture)
+
+	emailChanged := !strings.EqualFold(update.Email, user.Email)
+	if emailChanged {
+		taken, err := s.users.EmailTaken(ctx, update.Email, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrEmailTaken
+		}
+
+		fields["email_verified"] = false
+		fields["email_verified_at"] = nil
+	}
+
+	if user.PhoneE164 != nil {
+		if normalized, err := utils.NormalizePhone(update.Number, s.countryCode); err != nil || normalized != *user.PhoneE164 {
+			fields["phone_e164"] = nil
+			fields["phone_verified_at"] = nil
+		}
+	}
+
+	if len(fields) == 0 {
+		return user, nil
+	}
+	if err := s.users.Update(ctx, user, fields); err != nil {
+		return nil, err
+	}
+
+	if emailChanged {
+		if err := s.sendVerification(ctx, user); err != nil {
+			logger.Error(ctx, "Failed to send verification email", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// To list users for staff, only those with role when it is set
+func (s *UserService) List(ctx context.Context, role string, page repository.Page) ([]models.User, error) {
+	return s.users.List(ctx, role, page)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"ezwait/internal/models"
+	"ezwait/internal/repository/memory"
+	"testing"
+)
+
+type userFixture struct {
+	service  *UserService
+	store    *memory.Store
+	sent     []uint // users a verification code was sent to
+	revoked  []uint // users signed out everywhere
+	customer models.User
+}
+
+// To build a UserService on a store holding one customer, recording the
+// verification emails and sign-outs instead of doing them
+func newUserFixture() *userFixture {
+	f := &userFixture{store: memory.New()}
+	f.customer = f.store.AddUser(models.User{Name: "Alan", Email: "alan@example.com", Role: models.RoleCustomer})
+	f.service = NewUserService(memory.NewUserRepository(f.store), "",
+		func(ctx context.Context, user *models.User) error {
+			f.sent = append(f.sent, user.ID)
+			return nil
+		},
+		func(ctx context.Context, userID uint) error {
+			f.revoked = append(f.revoked, userID)
+			return nil
+		})
+	return f
+}
+
+func TestRegisterSendsVerification(t *testing.T) {
+	f := newUserFixture()
+
+	user := models.User{Name: "Grace", Email: "grace@example.com", Role: models.RoleStylist}
+	if err := f.service.Register(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID == 0 {
+		t.Fatal("registered user has no ID")
+	}
+	if len(f.sent) != 1 || f.sent[0] != user.ID {
+		t.Fatalf("verification sent to %v, want [%d]", f.sent, user.ID)
+	}
+}
+
+func TestRegisterRefusesTakenEmail(t *testing.T) {
+	f := newUserFixture()
+
+	user := models.User{Name: "Alan", Email: "ALAN@example.com", Role: models.RoleCustomer}
+	if err := f.service.Register(context.Background(), &user); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("got %v, want ErrEmailTaken", err)
+	}
+	if len(f.sent) != 0 {
+		t.Fatalf("verification sent to %v for a refused registration", f.sent)
+	}
+}
+
+func TestChangeRoleSignsOut(t *testing.T) {
+	f := newUserFixture()
+	ctx := context.Background()
+
+	user, err := f.service.ChangeRole(ctx, f.customer.ID, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleAdmin {
+		t.Fatalf("role is %q, want %q", user.Role, models.RoleAdmin)
+	}
+	if len(f.revoked) != 1 || f.revoked[0] != f.customer.ID {
+		t.Fatalf("signed out %v, want [%d]", f.revoked, f.customer.ID)
+	}
+
+	// To leave sessions alone when the role doesn't change
+	if _, err := f.service.ChangeRole(ctx, f.customer.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.revoked) != 1 {
+		t.Fatalf("signed out %v after a no-op change", f.revoked)
+	}
+}
+
+func TestChangeRoleErrors(t *testing.T) {
+	f := newUserFixture()
+	ctx := context.Background()
+
+	if _, err := f.service.ChangeRole(ctx, f.customer.ID, "owner"); !errors.Is(err, ErrUnknownRole) {
+		t.Fatalf("unknown role: got %v, want ErrUnknownRole", err)
+	}
+	if _, err := f.service.ChangeRole(ctx, 999, models.RoleAdmin); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("missing user: got %v, want ErrUserNotFound", err)
+	}
+	if len(f.revoked) != 0 {
+		t.Fatalf("signed out %v after failed changes", f.revoked)
+	}
+}
+
+func TestRegisterNormalizesEmail(t *testing.T) {
+	f := newUserFixture()
+	ctx := context.Background()
+
+	user := models.User{Name: "Grace", Email: " Grace@Example.com", Role: models.RoleStylist}
+	if err := f.service.Register(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "grace@example.com" {
+		t.Fatalf("stored %q, want it in lowercase", user.Email)
+	}
+
+	found, err := f.service.FindByEmail(ctx, "GRACE@example.com")
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("lookup in another case: got %v, %v", found, err)
+	}
+}
+
+func TestUpdateProfileWritesOnlyChangedFields(t *testing.T) {
+	f := newUserFixture()
+	ctx := context.Background()
+
+	alan := f.store.AddUser(models.User{ID: f.customer.ID, Name: "Alan", Email: "alan@example.com", Role: models.RoleStylist, Password: "hash", EmailVerified: true})
+
+	user, err := f.service.UpdateProfile(ctx, alan.ID, ProfileUpdate{Name: "Alan", Email: "Alan.T@example.com", Location: "Lagos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alan.t@example.com" || user.EmailVerified {
+		t.Fatalf("got email %q verified %v, want the new email unverified", user.Email, user.EmailVerified)
+	}
+
+	stored, err := memory.NewUserRepository(f.store).FindByID(ctx, alan.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != models.RoleStylist || stored.Password != "hash" {
+		t.Fatalf("role %q and password %q were overwritten", stored.Role, stored.Password)
+	}
+	if stored.Email != user.Email || stored.Location != "Lagos" {
+		t.Fatalf("stored %+v, want the updated profile", stored)
+	}
+	if len(f.sent) != 1 || f.sent[0] != alan.ID {
+		t.Fatalf("verification sent to %v, want [%d]", f.sent, alan.ID)
+	}
+}
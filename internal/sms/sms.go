@@ -0,0 +1,102 @@
+package sms
+
+import (
+	"context"
+	"ezwait/config"
+	"ezwait/pkg/logger"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMSSender delivers text messages. TwilioSender is used in production,
+// ConsoleSender for local development and tests.
+type SMSSender interface {
+	Send(to, body string) error
+}
+
+// To build a sender for cfg.Driver ("twilio" or "console", defaults to "console")
+func New(cfg config.SMSConfig) (SMSSender, error) {
+	switch cfg.Driver {
+	case "", "console":
+		return &ConsoleSender{Dir: cfg.Dir}, nil
+	case "twilio":
+		s := &TwilioSender{
+			AccountSID: cfg.TwilioAccountSID,
+			AuthToken:  cfg.TwilioAuthToken,
+			From:       cfg.TwilioFrom,
+			Client:     &http.Client{Timeout: 10 * time.Second},
+		}
+		if s.AccountSID == "" || s.AuthToken == "" || s.From == "" {
+			return nil, fmt.Errorf("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required for the twilio sms driver")
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unknown SMS_DRIVER %q", cfg.Driver)
+}
+
+// ConsoleSender logs messages and, when Dir is set, appends them to Dir/sms.log
+type ConsoleSender struct {
+	Dir string
+}
+
+func (s *ConsoleSender) Send(to, body string) error {
+	logger.Info(context.Background(), "📱 SMS", "to", to, "body", body)
+
+	if s.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.Dir, "sms.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, body)
+	return err
+}
+
+// TwilioSender sends messages with the Twilio Messages API
+type TwilioSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+	Client     *http.Client
+}
+
+func (s *TwilioSender) Send(to, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", s.From)
+	form.Set("Body", body)
+
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", s.AccountSID)
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("twilio responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package testenv provides a throwaway Postgres for the integration tests,
+// started from binaries already on the machine so no network or Docker
+// registry is needed.
+package testenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrNoPostgres means no database can be provided, tests skip on it
+var ErrNoPostgres = errors.New("no Postgres available: set TEST_DATABASE_URL, or PG_BIN to a directory with initdb and pg_ctl")
+
+// Where Postgres binaries are usually installed when they aren't on PATH
+var searchDirs = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/pgsql-*/bin",
+	"/usr/local/pgsql/bin",
+	"/opt/homebrew/opt/postgresql*/bin",
+	"/usr/local/opt/postgresql*/bin",
+}
+
+// Postgres is a database for one test run
+type Postgres struct {
+	dsn string
+	bin string
+	dir string
+}
+
+// To provide a database:
+//
+//   - TEST_DATABASE_URL, when set, is used as is. It must point at a
+//     throwaway database, the tests empty its tables.
+//   - otherwise a server is created in a temporary directory with initdb and
+//     pg_ctl from PG_BIN, PATH or the usual install locations, listening on a
+//     free local port until Stop.
+//
+// The error wraps ErrNoPostgres when neither is available.
+func StartPostgres() (*Postgres, error) {
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		return &Postgres{dsn: dsn}, nil
+	}
+
+	bin, err := findBinaries()
+	if err != nil {
+		return nil, err
+	}
+
+	// initdb refuses to run as root
+	if os.Geteuid() == 0 {
+		return nil, fmt.Errorf("%w: initdb can't run as root", ErrNoPostgres)
+	}
+
+	dir, err := os.MkdirTemp("", "ezwait-pg-")
+	if err != nil {
+		return nil, err
+	}
+	pg := &Postgres{bin: bin, dir: dir}
+
+	port, err := freePort()
+	if err != nil {
+		pg.cleanup()
+		return nil, err
+	}
+
+	data := filepath.Join(dir, "data")
+	if err := pg.run("initdb", "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-locale", "--no-sync"); err != nil {
+		pg.cleanup()
+		return nil, err
+	}
+
+	// To listen on localhost only, with the socket in the temp dir, times in
+	// UTC and fsync off since the data is thrown away
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c timezone=UTC -c fsync=off", port, dir)
+	if err := pg.run("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "start"); err != nil {
+		pg.cleanup()
+		return nil, err
+	}
+
+	pg.dsn = fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	return pg, nil
+}
+
+// To connect to the database
+func (p *Postgres) DSN() string {
+	return p.dsn
+}
+
+// To stop the server and remove its files, a TEST_DATABASE_URL database is
+// left alone
+func (p *Postgres) Stop() error {
+	if p.dir == "" {
+		return nil
+	}
+
+	err := p.run("pg_ctl", "-D", filepath.Join(p.dir, "data"), "-m", "immediate", "-w", "stop")
+	p.cleanup()
+	return err
+}
+
+func (p *Postgres) cleanup() {
+	os.RemoveAll(p.dir)
+}
+
+// To run a Postgres binary, its output becomes the error when it fails
+func (p *Postgres) run(name string, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command(filepath.Join(p.bin, name), args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
+
+// To find the directory holding initdb and pg_ctl
+func findBinaries() (string, error) {
+	if dir := os.Getenv("PG_BIN"); dir != "" {
+		if !hasBinaries(dir) {
+			return "", fmt.Errorf("PG_BIN %q has no initdb and pg_ctl", dir)
+		}
+		return dir, nil
+	}
+
+	if path, err := exec.LookPath("pg_ctl"); err == nil {
+		if dir := filepath.Dir(path); hasBinaries(dir) {
+			return dir, nil
+		}
+	}
+
+	for _, pattern := range searchDirs {
+		matches, _ := filepath.Glob(pattern)
+		for _, dir := range matches {
+			if hasBinaries(dir) {
+				return dir, nil
+			}
+		}
+	}
+
+	return "", ErrNoPostgres
+}
+
+func hasBinaries(dir string) bool {
+	for _, name := range []string{"initdb", "pg_ctl"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// To pick a port nothing listens on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"errors"
+	"ezwait/pkg/logger"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// To start a server span for each request, continuing the caller's trace
+// from the traceparent header. The span travels in c.UserContext(), so
+// queries run with db.WithContext(c.UserContext()) become its children.
+func Middleware(c *fiber.Ctx) error {
+	carrier := propagation.MapCarrier{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		carrier.Set(strings.ToLower(string(key)), string(value))
+	})
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+
+	ctx, span := Tracer().Start(ctx, c.Method()+" "+c.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.ClientAddress(c.IP()),
+			semconv.UserAgentOriginal(c.Get(fiber.HeaderUserAgent)),
+		),
+	)
+	defer span.End()
+
+	// To link log lines to the trace
+	if spanCtx := span.SpanContext(); spanCtx.IsValid() {
+		ctx = logger.WithAttrs(ctx, "trace_id", spanCtx.TraceID().String())
+	}
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	// To name the span by route pattern once routing has happened
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(semconv.HTTPRoute(route))
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		span.RecordError(err)
+		status = fiber.StatusInternalServerError
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		}
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+
+	return err
+}
@@ -0,0 +1,9 @@
+package utils
+
+import "strings"
+
+// To normalize an email address the way it is stored and looked up, so
+// addresses differing only in case belong to one account
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
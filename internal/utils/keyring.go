@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"ezwait/config"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is one entry of the key ring. Keys without a private half can
+// only verify tokens, which is how retired asymmetric keys are kept around.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// To check if the key is able to sign new tokens
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeyRing holds the active signing key plus every key still accepted for verification
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// To build a key ring, the active key must be part of keys and able to sign
+func NewKeyRing(activeKID string, keys ...*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("jwt key is missing a kid")
+		}
+		if _, exists := ring.keys[k.ID]; exists {
+			return nil, fmt.Errorf("duplicate jwt kid %q", k.ID)
+		}
+		ring.keys[k.ID] = k
+	}
+
+	active, ok := ring.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active jwt kid %q not found", activeKID)
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active jwt kid %q has no private key", activeKID)
+	}
+	ring.active = active
+
+	return ring, nil
+}
+
+// To load signing keys, in order of precedence:
+//   - KeysFile (JWT_KEYS_FILE): a JSON key ring file (see keyFile)
+//   - PrivateKeyFile (JWT_PRIVATE_KEY_FILE) with Algorithm (JWT_ALG, RS256 or EdDSA)
+//   - Secret or SecretFile (JWT_SECRET, JWT_SECRET_FILE) for HS256
+//
+// KeyID (JWT_KID) names the key for the single-key forms and defaults to "default".
+func LoadKeyRing(cfg config.JWTConfig) (*KeyRing, error) {
+	if cfg.KeysFile != "" {
+		return LoadKeyRingFile(cfg.KeysFile)
+	}
+
+	kid := cfg.KeyID
+	if kid == "" {
+		kid = "default"
+	}
+
+	if cfg.PrivateKeyFile != "" {
+		key, err := loadPrivateKey(kid, cfg.Algorithm, cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyRing(kid, key)
+	}
+
+	secret := cfg.Secret
+	if cfg.SecretFile != "" {
+		b, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT_SECRET_FILE: %w", err)
+		}
+		secret = strings.TrimSpace(string(b))
+	}
+
+	if secret == "" {
+		return nil, errors.New("no JWT signing key configured, set JWT_SECRET, JWT_PRIVATE_KEY_FILE or JWT_KEYS_FILE")
+	}
+
+	key, err := NewHMACKey(kid, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyRing(kid, key)
+}
+
+// keyFile is the JSON layout of JWT_KEYS_FILE. Relative paths are resolved
+// against the directory of the file itself.
+//
+//	{
+//	  "active": "2025-06",
+//	  "keys": [
+//	    {"kid": "2025-06", "alg": "EdDSA", "private_key_file": "2025-06.pem"},
+//	    {"kid": "2025-01", "alg": "RS256", "public_key_file": "2025-01.pub.pem"},
+//	    {"kid": "legacy", "alg": "HS256", "secret": "..."}
+//	  ]
+//	}
+type keyFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		KID            string `json:"kid"`
+		Alg            string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// To load a key ring from a JSON key file
+func LoadKeyRingFile(path string) (*KeyRing, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading jwt keys file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("parsing jwt keys file: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var keys []*SigningKey
+	for _, k := range file.Keys {
+		var (
+			key *SigningKey
+			err error
+		)
+
+		switch {
+		case k.Secret != "":
+			if k.Alg != "" && k.Alg != AlgHS256 {
+				return nil, fmt.Errorf("jwt kid %q: secrets are only valid for HS256", k.KID)
+			}
+			key, err = NewHMACKey(k.KID, []byte(k.Secret))
+		case k.PrivateKeyFile != "":
+			key, err = loadPrivateKey(k.KID, k.Alg, resolve(k.PrivateKeyFile))
+		case k.PublicKeyFile != "":
+			key, err = loadPublicKey(k.KID, k.Alg, resolve(k.PublicKeyFile))
+		default:
+			err = fmt.Errorf("jwt kid %q has no key material", k.KID)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyRing(file.Active, keys...)
+}
+
+// To create an HS256 key from a shared secret
+func NewHMACKey(kid string, secret []byte) (*SigningKey, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt kid %q: HS256 secret must be at least 32 bytes", kid)
+	}
+
+	return &SigningKey{ID: kid, Algorithm: AlgHS256, signKey: secret, verifyKey: secret}, nil
+}
+
+// To create a signing key from an RSA or Ed25519 private key
+func NewPrivateKey(kid string, private crypto.Signer) (*SigningKey, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Algorithm: AlgRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Algorithm: AlgEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	}
+
+	return nil, fmt.Errorf("jwt kid %q: unsupported private key type %T", kid, private)
+}
+
+// To create a verification-only key from an RSA or Ed25519 public key
+func NewPublicKey(kid string, public crypto.PublicKey) (*SigningKey, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return &SigningKey{ID: kid, Algorithm: AlgRS256, verifyKey: k}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: kid, Algorithm: AlgEdDSA, verifyKey: k}, nil
+	}
+
+	return nil, fmt.Errorf("jwt kid %q: unsupported public key type %T", kid, public)
+}
+
+func loadPrivateKey(kid, alg, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt kid %q: parsing private key: %w", kid, err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("jwt kid %q: unsupported private key type %T", kid, parsed)
+	}
+
+	key, err := NewPrivateKey(kid, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, checkAlg(key, alg)
+}
+
+func loadPublicKey(kid, alg, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt kid %q: parsing public key: %w", kid, err)
+	}
+
+	key, err := NewPublicKey(kid, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, checkAlg(key, alg)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	return block, nil
+}
+
+// To make sure a declared algorithm matches the key material
+func checkAlg(key *SigningKey, alg string) error {
+	if alg != "" && alg != key.Algorithm {
+		return fmt.Errorf("jwt kid %q: key type requires %s, not %s", key.ID, key.Algorithm, alg)
+	}
+	return nil
+}
+
+// To sign claims with the active key, stamping its kid in the header
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.active.method(), claims)
+	token.Header["kid"] = r.active.ID
+
+	return token.SignedString(r.active.signKey)
+}
+
+// To parse and verify a token. The kid selects the key and the token's alg
+// must match that key exactly, so an HS256 token can never be checked against
+// an RSA public key and "none" is never accepted.
+func (r *KeyRing) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := r.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods(r.algorithms()), jwt.WithExpirationRequired())
+}
+
+func (r *KeyRing) algorithms() []string {
+	seen := map[string]bool{}
+	var algs []string
+	for _, k := range r.keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	return algs
+}
+
+// JWK is the public representation of a verification key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// To list the public keys of the ring as a JWKS. Shared HS256 secrets are never published.
+func (r *KeyRing) JWKS() []JWK {
+	keys := []JWK{}
+	for _, k := range r.keys {
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: k.ID,
+				Alg: k.Algorithm,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: k.ID,
+				Alg: k.Algorithm,
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// To normalize a phone number to E.164 (+<country><number>). Numbers written
+// in national format (leading 0) get defaultCountryCode, e.g. "234".
+func NormalizePhone(raw, defaultCountryCode string) (string, error) {
+	s := strings.TrimSpace(raw)
+
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		international = true
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		international = true
+		s = s[2:]
+	}
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// To drop common separators
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	number := digits.String()
+	if !international {
+		if defaultCountryCode == "" || !strings.HasPrefix(number, "0") {
+			return "", ErrInvalidPhone
+		}
+		number = strings.TrimLeft(defaultCountryCode, "+") + strings.TrimPrefix(number, "0")
+	}
+
+	// E.164 allows at most 15 digits and country codes never start with 0
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+
+	return "+" + number, nil
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"ezwait/internal/models"
 	"time"
@@ -8,38 +12,59 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecretKey = []byte("oi3hugj-0987ewh")
+const (
+	// Every token carries a "typ" claim so a challenge token can never pass as an access token
+	TokenTypeAccess          = "access"
+	TokenTypeTwoFactorLogin  = "2fa_login"
+	TokenTypeTwoFactorEnroll = "2fa_enroll"
+	TokenTypeOIDCSignup      = "oidc_signup"
+	TokenTypeAccountRestore  = "account_restore"
+	TokenTypeDataExport      = "data_export"
 
-// To generate a JWT for a user
-func GenerateToken(user *models.User) (string, error) {
+	ChallengeTokenTTL = 5 * time.Minute
+)
+
+// To generate a short-lived access JWT for a user within a session family,
+// valid for ttl. Clients renew it with a refresh token.
+func (r *KeyRing) GenerateToken(user *models.User, sessionID string, ttl time.Duration) (string, string, time.Time, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
 
 	claims := jwt.MapClaims{
+		"typ":  TokenTypeAccess,
 		"user": user.ID,
 		"role": user.Role,
-		"exp":  time.Now().Add(24 * time.Hour).Unix(),
+		"jti":  jti,
+		"sid":  sessionID,
+		"iat":  now.Unix(),
+		"exp":  expiresAt.Unix(),
 	}
 
-	// To create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// To sign the token
-	signedToken, err := token.SignedString(jwtSecretKey)
+	// To create and sign the token with the active key
+	signedToken, err := r.Sign(claims)
 	if err != nil {
-		return "", err
+		return "", "", time.Time{}, err
 	}
 
-	return signedToken, nil
+	return signedToken, jti, expiresAt, nil
 }
 
 // To validates the token and return the claims
-func VerifyToken(tokenStr string) (*jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecretKey, nil
-	})
-
-	if err != nil || !token.Valid {
+func (r *KeyRing) VerifyToken(tokenStr string) (*jwt.MapClaims, error) {
+	token, err := r.Parse(tokenStr, jwt.MapClaims{})
+	if err != nil {
 		return nil, err
 	}
 
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
 	// To extract claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 
@@ -49,3 +74,69 @@ func VerifyToken(tokenStr string) (*jwt.MapClaims, error) {
 
 	return &claims, nil
 }
+
+// To generate a short-lived token proving the first login step for a user
+func (r *KeyRing) GenerateChallengeToken(user *models.User, tokenType string) (string, error) {
+	return r.GenerateTypedToken(tokenType, jwt.MapClaims{"user": user.ID}, ChallengeTokenTTL)
+}
+
+// To verify a challenge token of the given type and return its user ID
+func (r *KeyRing) VerifyChallengeToken(tokenStr, tokenType string) (uint, error) {
+	claims, err := r.VerifyTypedToken(tokenStr, tokenType)
+	if err != nil {
+		return 0, err
+	}
+
+	userID, ok := claims["user"].(float64)
+	if !ok {
+		return 0, errors.New("invalid claims")
+	}
+
+	return uint(userID), nil
+}
+
+// To sign a short-lived token of the given type carrying extra claims
+func (r *KeyRing) GenerateTypedToken(tokenType string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims["typ"] = tokenType
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	return r.Sign(claims)
+}
+
+// To verify a token and make sure it has the expected type
+func (r *KeyRing) VerifyTypedToken(tokenStr, tokenType string) (jwt.MapClaims, error) {
+	claims, err := r.VerifyToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ, _ := (*claims)["typ"].(string); typ != tokenType {
+		return nil, errors.New("unexpected token type")
+	}
+
+	return *claims, nil
+}
+
+// To generate a URL-safe random token of n random bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// To hash an opaque token before storing or looking it up
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+package validation
+
+import (
+	"errors"
+	"ezwait/internal/passwords"
+	"ezwait/internal/utils"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes in the "code" field of a validation error response
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeValidationFailed = "validation_failed"
+)
+
+// Field error codes in the "fields" map, stable so the mobile app can localize them
+const (
+	FieldRequired      = "required"
+	FieldInvalid       = "invalid"
+	FieldInvalidEmail  = "invalid_email"
+	FieldInvalidPhone  = "invalid_phone"
+	FieldInvalidDate   = "invalid_date"
+	FieldInvalidChoice = "invalid_choice"
+	FieldTooShort      = "too_short"
+	FieldTooLong       = "too_long"
+	FieldTooSmall      = "too_small"
+	FieldTooLarge      = "too_large"
+	FieldMismatch      = "mismatch"
+	FieldMustBeAfter   = "must_be_after"
+	FieldMustBeFuture  = "must_be_future"
+	FieldTaken         = "taken"
+	FieldTooWeak       = passwords.CodeTooWeak
+	FieldPersonalInfo  = passwords.CodePersonalInfo
+	FieldBreached      = passwords.CodeBreached
+)
+
+// Error is the body of every validation failure:
+// {"error": {"code": "validation_failed", "fields": {"email": "invalid_email"}}}
+type Error struct {
+	Code   string            `json:"code"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+// Validator checks request bodies against their `validate` tags
+type Validator struct {
+	validate *validator.Validate
+}
+
+// To build a Validator whose "phone" rule gives numbers in national format
+// countryCode, e.g. "234"
+func New(countryCode string) *Validator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// To report fields by their JSON name
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+
+	// To accept any number NormalizePhone can turn into E.164
+	_ = v.RegisterValidation("phone", func(fl validator.FieldLevel) bool {
+		_, err := utils.NormalizePhone(fl.Field().String(), countryCode)
+		return err == nil
+	})
+
+	return &Validator{validate: v}
+}
+
+// To build an error for a single field, for checks made outside struct tags
+func FieldError(field, code string) *Error {
+	return &Error{
+		Code:   CodeValidationFailed,
+		Fields: map[string]string{field: code},
+	}
+}
+
+// To turn a password policy rejection into an error for the given field,
+// other errors are returned as they are
+func Password(field string, err error) error {
+	var policyErr *passwords.PolicyError
+	if errors.As(err, &policyErr) {
+		return FieldError(field, policyErr.Code)
+	}
+
+	return err
+}
+
+// To validate a struct against its `validate` tags
+func (val *Validator) Struct(v interface{}) error {
+	err := val.validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	root := reflect.Indirect(reflect.ValueOf(v)).Type().Name()
+
+	result := &Error{Code: CodeValidationFailed, Fields: map[string]string{}}
+	for _, fe := range fieldErrs {
+		result.Fields[strings.TrimPrefix(fe.Namespace(), root+".")] = fieldCode(fe)
+	}
+
+	return result
+}
+
+// To parse the request body into v and validate it
+func (val *Validator) ParseBody(c *fiber.Ctx, v interface{}) error {
+	if err := c.BodyParser(v); err != nil {
+		return &Error{Code: CodeInvalidBody}
+	}
+
+	return val.Struct(v)
+}
+
+// To send a validation error to the client
+func Respond(c *fiber.Ctx, err error) error {
+	var validationErr *Error
+	if !errors.As(err, &validationErr) {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to validate request"})
+	}
+
+	return c.Status(400).JSON(fiber.Map{"error": validationErr})
+}
+
+func fieldCode(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if", "required_without":
+		return FieldRequired
+	case "email":
+		return FieldInvalidEmail
+	case "phone":
+		return FieldInvalidPhone
+	case "datetime":
+		return FieldInvalidDate
+	case "oneof":
+		return FieldInvalidChoice
+	case "eqfield":
+		return FieldMismatch
+	case "gtfield":
+		return FieldMustBeAfter
+	case "min", "gte", "gt":
+		if isSized(fe.Kind()) {
+			return FieldTooShort
+		}
+		return FieldTooSmall
+	case "max", "lte", "lt":
+		if isSized(fe.Kind()) {
+			return FieldTooLong
+		}
+		return FieldTooLarge
+	}
+
+	return FieldInvalid
+}
+
+func isSized(kind reflect.Kind) bool {
+	return kind == reflect.String || kind == reflect.Slice || kind == reflect.Map
+}
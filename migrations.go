@@ -1,34 +0,0 @@
-package main
-
-import (
-	"log"
-	"os"
-
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/joho/godotenv"
-)
-
-func runMigrations() {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	databaseURL := os.Getenv("DATABASE_URL")
-
-	m, err := migrate.New(
-		"file://db/migrations",
-		databaseURL,
-	)
-
-	if err != nil {
-		log.Fatalf("Failed to initialize migrations: %v", err)
-	}
-
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Fatalf("Migration failed: %v", err)
-	}
-
-	log.Println("Migrations applied successfully")
-}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// DefaultSlowQueryThreshold is used when DB_SLOW_QUERY_MS is not set
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+// GormLogger sends GORM logs to slog. Failed queries are errors, queries
+// slower than SlowThreshold are warnings and everything else is debug.
+// Query parameters are never logged, only placeholders.
+type GormLogger struct {
+	SlowThreshold time.Duration
+	Level         gormlogger.LogLevel
+}
+
+// To build a GORM logger with the given slow-query threshold
+func NewGormLogger(slowThreshold time.Duration) *GormLogger {
+	if slowThreshold <= 0 {
+		slowThreshold = DefaultSlowQueryThreshold
+	}
+
+	return &GormLogger{SlowThreshold: slowThreshold, Level: gormlogger.Info}
+}
+
+func (l *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	copy := *l
+	copy.Level = level
+	return &copy
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormlogger.Info {
+		Info(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormlogger.Warn {
+		Warn(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.Level >= gormlogger.Error {
+		Error(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.Level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.Level >= gormlogger.Error:
+		sql, rows := fc()
+		Error(ctx, "Query failed", "sql", sql, "rows", rows, "duration", elapsed, "error", err)
+	case elapsed > l.SlowThreshold && l.Level >= gormlogger.Warn:
+		sql, rows := fc()
+		Warn(ctx, "Slow query", "sql", sql, "rows", rows, "duration", elapsed, "threshold", l.SlowThreshold)
+	case l.Level >= gormlogger.Info && slog.Default().Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		Debug(ctx, "Query", "sql", sql, "rows", rows, "duration", elapsed)
+	}
+}
+
+// To keep query parameters, which can be emails or token hashes, out of the logs
+func (l *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
@@ -1 +1,153 @@
+// Package logger is the structured application logger, built on log/slog.
+// It writes JSON in production and text in development, adds request-scoped
+// fields (request ID, user ID, role) from the context and redacts secrets.
 package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Keys of the request-scoped fields
+const (
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+	RoleKey      = "role"
+)
+
+const redacted = "[REDACTED]"
+
+// To set up the default logger writing to stdout in format (json or text)
+// at level (debug, info, warn, error). It also routes the standard log
+// package through it.
+func Init(format, level string) {
+	slog.SetDefault(New(os.Stdout, format, ParseLevel(level)))
+}
+
+// To build a logger writing to w in the given format
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(&contextHandler{Handler: handler})
+}
+
+// To read a level name, unknown names fall back to info
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+
+	return slog.LevelInfo
+}
+
+type contextKey struct{}
+
+// To attach fields to every record logged with the returned context
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	attrs := append([]slog.Attr{}, existing...)
+
+	record := slog.Record{}
+	record.Add(args...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// contextHandler adds the fields stored by WithAttrs to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// To hide values of keys that look like credentials
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindGroup && IsSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	return a
+}
+
+// To tell whether a field name holds a secret, e.g. "password" or "refresh_token"
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+
+	switch key {
+	case "code", "otp", "pin":
+		return true
+	}
+
+	for _, part := range []string{"password", "passwd", "secret", "token", "authorization", "cookie", "api_key", "apikey", "private_key"} {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// To log at debug level with the request fields in ctx
+func Debug(ctx context.Context, msg string, args ...any) {
+	slog.DebugContext(ctx, msg, args...)
+}
+
+// To log at info level with the request fields in ctx
+func Info(ctx context.Context, msg string, args ...any) {
+	slog.InfoContext(ctx, msg, args...)
+}
+
+// To log at warn level with the request fields in ctx
+func Warn(ctx context.Context, msg string, args ...any) {
+	slog.WarnContext(ctx, msg, args...)
+}
+
+// To log at error level with the request fields in ctx
+func Error(ctx context.Context, msg string, args ...any) {
+	slog.ErrorContext(ctx, msg, args...)
+}
+
+// To log an error and exit, for failures during startup
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}